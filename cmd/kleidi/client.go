@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/beezy-dev/kleidi/internal/client"
+	"github.com/beezy-dev/kleidi/internal/config"
+	"github.com/beezy-dev/kleidi/internal/utils"
+)
+
+const clientTimeOut = 10 * time.Second
+
+// envelope is the output of "kleidi encrypt" and the input of "kleidi decrypt".
+// Byte slices are encoded in base64.
+type envelope struct {
+	KeyID       string            `json:"keyID"`
+	Ciphertext  []byte            `json:"ciphertext"`
+	Annotations map[string][]byte `json:"annotations,omitempty"`
+}
+
+// clientFlags registers the flags shared by the commands talking to a running plugin.
+func clientFlags(fs *flag.FlagSet) (listenAddr, uid *string, timeout *time.Duration) {
+	listenAddr = fs.String("listen", config.Default().Server.Listen, "gRPC listen address of the running plugin")
+	uid = fs.String("uid", fmt.Sprintf("kleidi-cli-%d", time.Now().UnixNano()), "KMS request uid, as logged by the plugin")
+	timeout = fs.Duration("timeout", clientTimeOut, "Request timeout")
+	return
+}
+
+func dial(listenAddr string) (*client.Client, error) {
+	addr, err := utils.ValidateListenAddr(listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	return client.New(addr)
+}
+
+func statusCmd(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	listenAddr, _, timeout := clientFlags(fs)
+	fs.Parse(args)
+
+	c, err := dial(*listenAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	status, err := c.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("version: %s\nhealthz: %s\nkeyID:   %s\n", status.Version, status.Healthz, status.KeyId)
+	if status.Healthz != "ok" {
+		return fmt.Errorf("/!\\ plugin is unhealthy")
+	}
+	return nil
+}
+
+func encryptCmd(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	listenAddr, uid, timeout := clientFlags(fs)
+	data := fs.String("data", "", "Data to encrypt (read from stdin if empty)")
+	fs.Parse(args)
+
+	plaintext := []byte(*data)
+	if len(plaintext) == 0 {
+		var err error
+		if plaintext, err = io.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	c, err := dial(*listenAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	resp, err := c.Encrypt(ctx, *uid, plaintext)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(os.Stdout).Encode(envelope{
+		KeyID:       resp.KeyId,
+		Ciphertext:  resp.Ciphertext,
+		Annotations: resp.Annotations,
+	})
+}
+
+func decryptCmd(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	listenAddr, uid, timeout := clientFlags(fs)
+	in := fs.String("in", "-", "File holding the output of \"kleidi encrypt\" (- for stdin)")
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var env envelope
+	if err := json.NewDecoder(r).Decode(&env); err != nil {
+		return fmt.Errorf("/!\\ invalid input, expecting the output of \"kleidi encrypt\": %v", err)
+	}
+
+	c, err := dial(*listenAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	resp, err := c.Decrypt(ctx, *uid, env.KeyID, env.Ciphertext, env.Annotations)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(resp.Plaintext)
+	return err
+}
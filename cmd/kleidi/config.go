@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/beezy-dev/kleidi/internal/config"
+	"github.com/beezy-dev/kleidi/internal/utils"
+)
+
+func configCmd(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return fmt.Errorf("/!\\ usage: kleidi config validate [flags]")
+	}
+
+	defaults := config.Default()
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	configFile := fs.String("config", "", "kleidi configuration document, YAML or JSON (env KLEIDI_CONFIG)")
+	fs.String("provider", defaults.Provider.Name, "KMS provider of the configuration (hvault, openbao, softhsm, tpm, localkey, kmip, awskms, azurekv, gcpkms, composite, layered, threshold, kekcache)")
+	fs.String("configfile", defaults.Provider.ConfigFile, "Provider config file path")
+	dryRun := fs.Bool("dry-run", false, "Also connect and authenticate to the backend and check the key presence, without opening the socket")
+	fs.Parse(args[1:])
+
+	cfg, err := loadConfig(fs, *configFile)
+	if err != nil {
+		return err
+	}
+	if _, err := utils.ValidateListenAddr(cfg.Server.Listen); err != nil {
+		return err
+	}
+	if _, err := decryptCacheOptions(cfg); err != nil {
+		return err
+	}
+	provider, err := utils.ValidateProvider(cfg.Provider.Name)
+	if err != nil {
+		return err
+	}
+	providerConfig, err := providerConfigSource(cfg)
+	if err != nil {
+		return err
+	}
+	if err := utils.ValidateProviderConfig(provider, providerConfig); err != nil {
+		return err
+	}
+	fmt.Println("OK: " + providerConfig.String() + " is a valid " + provider + " configuration")
+
+	if *dryRun {
+		if err := utils.DryRunProvider(provider, providerConfig); err != nil {
+			return fmt.Errorf("/!\\ dry-run failed: %v", err)
+		}
+		fmt.Println("OK: " + provider + " backend reachable, authenticated and key found")
+	}
+	return nil
+}
@@ -7,12 +7,24 @@ Apache 2.0 License
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"go.uber.org/zap"
+	"log"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/beezy-dev/kleidi/internal/utils"
+	"github.com/beezy-dev/kleidi/internal/audit"
+	"github.com/beezy-dev/kleidi/internal/config"
+	"github.com/beezy-dev/kleidi/internal/health"
 	"github.com/beezy-dev/kleidi/internal/logger"
+	"github.com/beezy-dev/kleidi/internal/metrics"
+	"github.com/beezy-dev/kleidi/internal/peercred"
+	"github.com/beezy-dev/kleidi/internal/providers"
+	"github.com/beezy-dev/kleidi/internal/tracing"
+	"github.com/beezy-dev/kleidi/internal/utils"
 )
 
 var (
@@ -20,54 +32,285 @@ var (
 	zapLog        *zap.Logger
 )
 
+const usage = `Kleidi, KMS Provider Plugin for Kubernetes.
+
+Usage:
+  kleidi [serve] [flags]          run the KMS plugin (default)
+  kleidi status [flags]           query the Status of a running plugin
+  kleidi encrypt [flags]          encrypt data with a running plugin
+  kleidi decrypt [flags]          decrypt the output of "kleidi encrypt" with a running plugin
+  kleidi config validate [flags]  validate a provider configuration
+  kleidi version                  print the version
+
+Run "kleidi <command> -h" for the flags of a command.
+`
+
 func main() {
 
+	args := os.Args[1:]
+	// Without a subcommand, the flags are the ones of serve for backward compatibility.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		os.Exit(serve(args))
+	}
+
+	var err error
+	switch args[0] {
+	case "serve":
+		os.Exit(serve(args[1:]))
+	case "status":
+		err = statusCmd(args[1:])
+	case "encrypt":
+		err = encryptCmd(args[1:])
+	case "decrypt":
+		err = decryptCmd(args[1:])
+	case "config":
+		err = configCmd(args[1:])
+	case "version":
+		fmt.Println("kleidi v" + kleidiVersion)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		err = fmt.Errorf("/!\\ unknown command %q", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+// serve runs the KMS plugin and returns its exit code, see the utils.Exit* codes.
+func serve(args []string) int {
+
 	// Generic vars considering the consistency across providers.
+	// The flags take precedence over the KLEIDI_* environment variables, which take
+	// precedence over the -config document.
+	defaults := config.Default()
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
 	var (
-		listenAddr         = flag.String("listen", "unix:///tmp/kleidi/kleidi-kms-plugin.socket", "gRPC listen address")
-		providerService    = flag.String("provider", "softhsm", "KMS provider to connect to (hvault, softhsm, tpm)")
-		providerConfigFile = flag.String("configfile", "/opt/kleidi/config.json", "Provider config file path")
-		debugMode          = flag.Bool("debugmode", false, "Enable debug mode")
+		configFile = fs.String("config", "", "kleidi configuration document, YAML or JSON (env KLEIDI_CONFIG)")
+		debugMode  = fs.Bool("debugmode", false, "Enable debug mode (same as -log-level=debug)")
 	)
+	fs.String("listen", defaults.Server.Listen, "gRPC listen address")
+	fs.String("socket-mode", defaults.Server.SocketMode, "Octal file mode of the unix socket")
+	fs.String("socket-owner", defaults.Server.SocketOwner, "User, name or uid, owning the unix socket (kleidi user if empty)")
+	fs.String("socket-group", defaults.Server.SocketGroup, "Group, name or gid, owning the unix socket (kleidi group if empty)")
+	fs.String("allowed-uids", "", "Comma-separated UIDs allowed to connect to the unix socket (any peer if no allowed-* is set)")
+	fs.String("allowed-gids", "", "Comma-separated GIDs allowed to connect to the unix socket")
+	fs.String("allowed-executables", "", "Comma-separated executable paths allowed to connect to the unix socket")
+	fs.String("drain-timeout", defaults.Server.DrainTimeout, "Period given to the in-flight requests to complete on shutdown")
+	fs.String("provider", defaults.Provider.Name, "KMS provider to connect to (hvault, openbao, softhsm, tpm, localkey, kmip, awskms, azurekv, gcpkms, composite, layered, threshold, kekcache)")
+	fs.String("configfile", defaults.Provider.ConfigFile, "Provider config file path")
+	fs.String("log-level", defaults.Logging.Level, "Log level: debug, info, warn or error")
+	fs.String("log-format", defaults.Logging.Format, "Log encoding: console or json")
+	fs.Bool("log-sampling", defaults.Logging.Sampling, "Sample repeated log entries on hot paths")
+	fs.String("metrics-listen", defaults.Metrics.Listen, "Prometheus metrics HTTP listen address, e.g. :9100 (disabled if empty)")
+	fs.String("health-listen", defaults.Health.Listen, "Liveness/readiness HTTP listen address, e.g. :8080 (disabled if empty)")
+	fs.String("audit-log", defaults.Audit.Target, "Audit log target: file:///path, syslog:// or unix:///path (disabled if empty)")
+	fs.Int("audit-max-size", defaults.Audit.MaxSizeMB, "Audit log file size in megabytes before rotation")
+	fs.Int("audit-max-backups", defaults.Audit.MaxBackups, "Number of rotated audit log files to retain")
+	fs.Int("decrypt-cache-size", defaults.DecryptCache.MaxEntries, "Maximum number of plaintexts in the decrypt cache (disabled if 0)")
+	fs.String("decrypt-cache-ttl", defaults.DecryptCache.TTL, "Period a plaintext is served from the decrypt cache after its decryption")
+	fs.String("otlp-endpoint", defaults.Tracing.OTLPEndpoint, "OpenTelemetry collector OTLP/gRPC endpoint, e.g. 127.0.0.1:4317 (tracing disabled if empty)")
 
 	// Parsing environment variables.
-	flag.Parse()
+	fs.Parse(args)
+	cfg, err := loadConfig(fs, *configFile)
+	if err != nil {
+		log.Fatalln("EXIT: " + err.Error())
+	}
+	if *debugMode {
+		cfg.Logging.Level = "debug"
+	}
+
 	// create logger and set is as default
-	zapLog = logger.CreateLogger(*debugMode)
+	zapLog, err = logger.CreateLogger(logger.Options{
+		Level:    cfg.Logging.Level,
+		Encoding: cfg.Logging.Format,
+		Sampling: cfg.Logging.Sampling,
+	})
+	if err != nil {
+		log.Fatalln("EXIT: unable to create logger: " + err.Error())
+	}
 	defer zapLog.Sync()
 	zap.ReplaceGlobals(zapLog)
+	// SIGUSR1 toggles the debug level at runtime.
+	logger.WatchLevelSignal()
 
 	zap.L().Info("Kleidi v" + kleidiVersion + ", KMS Provider Plugin for Kubernetes. " +
 		"License Apache 2.0 - https://github.com/beezy-dev/kleidi")
 
 	// Validating the socket location.
-	addr, err := utils.ValidateListenAddr(*listenAddr)
+	addr, err := utils.ValidateListenAddr(cfg.Server.Listen)
 	if err != nil {
-		zap.L().Fatal("EXIT: flag -listen set to" + *listenAddr + " failed with error: " + err.Error())
+		zap.L().Fatal("EXIT: invalid flag -listen", zap.String("listen", cfg.Server.Listen), zap.Error(err))
+	}
+
+	// Using the socket passed by systemd socket activation, if any, instead of the -listen one.
+	listener, err := utils.SystemdListener()
+	if err != nil {
+		zap.L().Fatal("EXIT: invalid socket activation", zap.Error(err))
+	}
+	if listener != nil && listener.Addr().String() != addr {
+		zap.L().Warn("systemd socket differs from flag -listen, using the systemd one",
+			zap.String("listen", addr), zap.String("socket", listener.Addr().String()))
+		addr = listener.Addr().String()
+	}
+
+	// Validating the socket permissions and its directory.
+	socketOpts, err := utils.ValidateSocketOptions(cfg.Server.SocketMode, cfg.Server.SocketOwner, cfg.Server.SocketGroup)
+	if err != nil {
+		zap.L().Fatal("EXIT: invalid socket permissions", zap.Error(err))
+	}
+	if err := utils.ValidateSocketDir(addr); err != nil {
+		zap.L().Fatal("EXIT: invalid socket directory", zap.String("socket", addr), zap.Error(err))
+	}
+	if utils.IsAbstractSocket(addr) && len(cfg.Server.AllowedPeers.UIDs)+len(cfg.Server.AllowedPeers.GIDs)+len(cfg.Server.AllowedPeers.Executables) == 0 {
+		zap.L().Warn("abstract socket without allowed peers, any process of the network namespace can connect", zap.String("socket", addr))
 	}
 
 	// Checking and cleaning an existing socket in case of ungraceful shutdown.
-	if cleanup := os.Remove(addr); cleanup != nil && !os.IsNotExist(cleanup) {
-		zap.L().Fatal("EXIT: unable to delete existing socket file " + addr + " from directory!")
+	// Abstract sockets have no file, and systemd owns the activated socket.
+	if listener == nil && !utils.IsAbstractSocket(addr) {
+		if cleanup := os.Remove(addr); cleanup != nil && !os.IsNotExist(cleanup) {
+			zap.L().Fatal("EXIT: unable to delete existing socket file from directory", zap.String("socket", addr), zap.Error(cleanup))
+		}
+	}
+
+	drainTimeOut, err := time.ParseDuration(cfg.Server.DrainTimeout)
+	if err != nil {
+		zap.L().Fatal("EXIT: invalid flag -drain-timeout", zap.String("drain-timeout", cfg.Server.DrainTimeout), zap.Error(err))
+	}
+
+	decryptCache, err := decryptCacheOptions(cfg)
+	if err != nil {
+		zap.L().Fatal("EXIT: invalid decrypt cache settings", zap.Error(err))
 	}
 
 	// Validating the provider.
-	provider, err := utils.ValidateProvider(*providerService)
+	provider, err := utils.ValidateProvider(cfg.Provider.Name)
 	if err != nil {
-		zap.L().Fatal("EXIT: flag -provider set to " + provider + " failed with error: " + err.Error())
+		zap.L().Fatal("EXIT: invalid flag -provider", logger.Provider(provider), zap.Error(err))
 	}
 
 	// Validating the provider config.
-	providerConfig, err := utils.ValidateConfigfile(*providerConfigFile)
+	providerConfig, err := providerConfigSource(cfg)
 	if err != nil {
-		zap.L().Fatal("EXIT: flag -configfile set to " + providerConfig + " failed with error: " + err.Error())
+		zap.L().Fatal("EXIT: invalid flag -configfile", zap.String("configfile", cfg.Provider.ConfigFile), zap.Error(err))
+	}
+	if err := utils.ValidateProviderConfig(provider, providerConfig); err != nil {
+		zap.L().Fatal("EXIT: invalid provider config", logger.Provider(provider), zap.Stringer("configfile", providerConfig), zap.Error(err))
 	}
 
-	debug := *debugMode
+	// Starting the optional Prometheus metrics endpoint.
+	metrics.Serve(cfg.Metrics.Listen)
+	// Starting the optional liveness/readiness endpoints.
+	health.Serve(cfg.Health.Listen)
+	// Starting the optional OTLP trace export.
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.OTLPEndpoint, kleidiVersion)
+	if err != nil {
+		zap.L().Fatal("EXIT: invalid flag -otlp-endpoint", zap.String("endpoint", cfg.Tracing.OTLPEndpoint), zap.Error(err))
+	}
+	defer shutdownTracing(context.Background())
+
+	// Opening the optional audit log of the key operations.
+	auditLog, err := audit.Open(audit.Options{
+		Target:     cfg.Audit.Target,
+		MaxSizeMB:  cfg.Audit.MaxSizeMB,
+		MaxBackups: cfg.Audit.MaxBackups,
+	})
+	if err != nil {
+		zap.L().Fatal("EXIT: invalid flag -audit-log", zap.String("target", cfg.Audit.Target), zap.Error(err))
+	}
+	defer auditLog.Close()
+
+	debug := zapLog.Core().Enabled(zap.DebugLevel)
 
 	//Starting the appropriate provider once previously validated.
 	//REFACTOR to a simple interface
 
-	utils.StartProvider(addr, provider, providerConfig, debug)
+	// The configuration document and the provider config file are read again on
+	// SIGHUP or when they change. The flags and the environment still apply.
+	reload := func() (string, providers.ConfigSource, error) {
+		cfg, err := loadConfig(fs, *configFile)
+		if err != nil {
+			return "", providers.ConfigSource{}, err
+		}
+		providerConfig, err := providerConfigSource(cfg)
+		return cfg.Provider.Name, providerConfig, err
+	}
+	var watchFiles []string
+	if path := configPath(*configFile); len(path) != 0 {
+		watchFiles = append(watchFiles, path)
+	}
+
+	return utils.StartProvider(utils.Options{
+		Addr:          addr,
+		Listener:      listener,
+		SocketOptions: socketOpts,
+		AllowedPeers: peercred.Policy{
+			UIDs:        cfg.Server.AllowedPeers.UIDs,
+			GIDs:        cfg.Server.AllowedPeers.GIDs,
+			Executables: cfg.Server.AllowedPeers.Executables,
+		},
+		Provider:       provider,
+		ProviderConfig: providerConfig,
+		Debug:          debug,
+		AuditLog:       auditLog,
+		Reload:         reload,
+		WatchFiles:     watchFiles,
+		DrainTimeOut:   drainTimeOut,
+		DecryptCache:   decryptCache,
+	})
+
+}
+
+// loadConfig loads the configuration document at path, or at KLEIDI_CONFIG when path is
+// empty, then applies the KLEIDI_* environment variables and the flags explicitly set on fs.
+func loadConfig(fs *flag.FlagSet, path string) (*config.Config, error) {
+	cfg, err := config.Load(configPath(path))
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || f.Name == "debugmode" {
+			return
+		}
+		err = cfg.Set(f.Name, f.Value.String())
+	})
+	return cfg, err
+}
 
+// configPath returns path, or KLEIDI_CONFIG when path is empty.
+func configPath(path string) string {
+	if len(path) == 0 {
+		return os.Getenv(config.EnvName("config"))
+	}
+	return path
+}
+
+// providerConfigSource returns the inline provider config of cfg, or its validated config file.
+func providerConfigSource(cfg *config.Config) (providers.ConfigSource, error) {
+	if len(cfg.Provider.Config) != 0 {
+		return providers.ConfigSource{Inline: cfg.Provider.Config}, nil
+	}
+	configFile, err := utils.ValidateConfigfile(cfg.Provider.ConfigFile)
+	return providers.ConfigSource{File: configFile}, err
+}
+
+// decryptCacheOptions returns the validated decrypt cache settings of cfg.
+func decryptCacheOptions(cfg *config.Config) (utils.DecryptCacheOptions, error) {
+	if cfg.DecryptCache.MaxEntries < 0 {
+		return utils.DecryptCacheOptions{}, fmt.Errorf("/!\\ decrypt cache size must be non-negative, 0 disabling the cache, got %d", cfg.DecryptCache.MaxEntries)
+	}
+	ttl, err := time.ParseDuration(cfg.DecryptCache.TTL)
+	if err != nil || ttl <= 0 {
+		return utils.DecryptCacheOptions{}, fmt.Errorf("/!\\ decrypt cache ttl must be a positive duration, got %q", cfg.DecryptCache.TTL)
+	}
+	return utils.DecryptCacheOptions{MaxEntries: cfg.DecryptCache.MaxEntries, TTL: ttl}, nil
 }
@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// genesisHash is the previous hash of the first entry of a chain.
+var genesisHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+// Entry is one audit record. It never carries the plaintext nor the ciphertext.
+type Entry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Operation string    `json:"op"`
+	Provider  string    `json:"provider"`
+	UID       string    `json:"uid,omitempty"`
+	KeyID     string    `json:"keyID,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs float64   `json:"latencyMs"`
+	Peer      string    `json:"peer,omitempty"`
+	// PrevHash and Hash chain the entries: Hash is the SHA-256 of PrevHash
+	// followed by the JSON encoding of the entry with an empty Hash.
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+// Logger appends hash-chained entries to a sink.
+type Logger struct {
+	mu       sync.Mutex
+	sink     io.WriteCloser
+	prevHash string
+}
+
+// NewLogger returns a Logger writing to sink and continuing the chain after prevHash.
+// An empty prevHash starts a new chain.
+func NewLogger(sink io.WriteCloser, prevHash string) *Logger {
+	if len(prevHash) == 0 {
+		prevHash = genesisHash
+	}
+	return &Logger{
+		sink:     sink,
+		prevHash: prevHash,
+	}
+}
+
+// Record chains and writes e as a single JSON line.
+func (l *Logger) Record(e Entry) error {
+	if l == nil {
+		return nil
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.PrevHash = l.prevHash
+	hash, err := e.hash()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.sink.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.prevHash = e.Hash
+	return nil
+}
+
+// Close closes the underlying sink.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.sink.Close()
+}
+
+func (e Entry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(e.PrevHash), data...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify reads the entries of r and checks the hash chain. It returns the hash of the
+// last entry, which a Logger appending to the same stream continues from.
+func Verify(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	prevHash := ""
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return prevHash, fmt.Errorf("/!\\ line %d: invalid entry: %v", line, err)
+		}
+		// the first entry read may continue a chain started in a rotated file.
+		if len(prevHash) != 0 && e.PrevHash != prevHash {
+			return prevHash, fmt.Errorf("/!\\ line %d: broken chain, previous hash %s does not match %s", line, e.PrevHash, prevHash)
+		}
+		hash, err := e.hash()
+		if err != nil {
+			return prevHash, err
+		}
+		if hash != e.Hash {
+			return prevHash, fmt.Errorf("/!\\ line %d: entry has been tampered with", line)
+		}
+		prevHash = e.Hash
+	}
+	return prevHash, scanner.Err()
+}
@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func record(t *testing.T, l *Logger, uids ...string) {
+	t.Helper()
+	for _, uid := range uids {
+		if err := l.Record(Entry{Operation: "encrypt", Provider: "softhsm", UID: uid, Outcome: OutcomeSuccess}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestHashChain(t *testing.T) {
+	buf := nopCloser{&bytes.Buffer{}}
+	l := NewLogger(buf, "")
+	record(t, l, "uid-1", "uid-2", "uid-3")
+
+	last, err := Verify(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("expected a valid chain, but got: %v", err)
+	}
+
+	t.Run("Chain continues after a restart", func(t *testing.T) {
+		record(t, NewLogger(buf, last), "uid-4")
+		if _, err := Verify(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Errorf("expected a valid chain, but got: %v", err)
+		}
+	})
+
+	t.Run("Tampered entry is detected", func(t *testing.T) {
+		tampered := strings.Replace(buf.String(), "uid-2", "uid-X", 1)
+		if _, err := Verify(strings.NewReader(tampered)); err == nil || !strings.Contains(err.Error(), "tampered") {
+			t.Errorf("expected a tampering error, but got: %v", err)
+		}
+	})
+
+	t.Run("Removed entry is detected", func(t *testing.T) {
+		lines := strings.SplitAfter(buf.String(), "\n")
+		removed := strings.Join(append(lines[:1:1], lines[2:]...), "")
+		if _, err := Verify(strings.NewReader(removed)); err == nil || !strings.Contains(err.Error(), "broken chain") {
+			t.Errorf("expected a broken chain error, but got: %v", err)
+		}
+	})
+
+	t.Run("Entries never carry payloads", func(t *testing.T) {
+		if strings.Contains(buf.String(), "plaintext") || strings.Contains(buf.String(), "ciphertext") {
+			t.Errorf("audit entries must not carry payloads: %s", buf.String())
+		}
+	})
+}
+
+func TestOpenFileChainBreak(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	open := func() *Logger {
+		t.Helper()
+		l, err := Open(Options{Target: "file://" + path, MaxSizeMB: 1})
+		if err != nil {
+			t.Fatalf("Open() error: %v", err)
+		}
+		return l
+	}
+	firstEntry := func() Entry {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Verify(bytes.NewReader(data)); err != nil {
+			t.Fatalf("expected a valid chain, but got: %v", err)
+		}
+		var e Entry
+		if err := json.Unmarshal(bytes.SplitN(data, []byte("\n"), 2)[0], &e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	l := open()
+	record(t, l, "uid-1", "uid-2")
+	l.Close()
+
+	t.Run("Tampered file is moved aside", func(t *testing.T) {
+		data, _ := os.ReadFile(path)
+		if err := os.WriteFile(path, bytes.Replace(data, []byte("uid-2"), []byte("uid-X"), 1), 0600); err != nil {
+			t.Fatal(err)
+		}
+		l := open()
+		record(t, l, "uid-3")
+		l.Close()
+		if e := firstEntry(); e.Operation != chainBreakOperation || !strings.Contains(e.Error, "failed verification") {
+			t.Errorf("expected a chain break entry, but got: %+v", e)
+		}
+		if aside, _ := filepath.Glob(path + ".broken-*"); len(aside) != 1 {
+			t.Errorf("expected the tampered file moved aside, but got: %v", aside)
+		}
+	})
+
+	t.Run("Missing file after a rotation is recorded", func(t *testing.T) {
+		if err := os.Rename(path, filepath.Join(dir, "audit-2025-08-01T10-00-00.000.log")); err != nil {
+			t.Fatal(err)
+		}
+		l := open()
+		l.Close()
+		if e := firstEntry(); e.Operation != chainBreakOperation || !strings.Contains(e.Error, "audit log missing") {
+			t.Errorf("expected a chain break entry, but got: %+v", e)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/peer"
+	"k8s.io/kms/pkg/service"
+)
+
+var _ service.Service = &auditedService{}
+
+// auditedService records an audit entry for every Encrypt and Decrypt call
+// made to the wrapped provider.
+type auditedService struct {
+	provider string
+	next     service.Service
+	audit    *Logger
+}
+
+// NewAuditedService wraps next so that each key operation is recorded in audit.
+func NewAuditedService(provider string, next service.Service, audit *Logger) service.Service {
+	return &auditedService{
+		provider: provider,
+		next:     next,
+		audit:    audit,
+	}
+}
+
+func (s *auditedService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	start := time.Now()
+	resp, err := s.next.Encrypt(ctx, uid, plaintext)
+	keyID := ""
+	if resp != nil {
+		keyID = resp.KeyID
+	}
+	s.record(ctx, "encrypt", uid, keyID, start, err)
+	return resp, err
+}
+
+func (s *auditedService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	start := time.Now()
+	plaintext, err := s.next.Decrypt(ctx, uid, req)
+	s.record(ctx, "decrypt", uid, req.KeyID, start, err)
+	return plaintext, err
+}
+
+func (s *auditedService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	return s.next.Status(ctx)
+}
+
+func (s *auditedService) record(ctx context.Context, op, uid, keyID string, start time.Time, err error) {
+	e := Entry{
+		Timestamp: start.UTC(),
+		Operation: op,
+		Provider:  s.provider,
+		UID:       uid,
+		KeyID:     keyID,
+		Outcome:   OutcomeSuccess,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	// the peer credentials are set when the connections are authorized.
+	if p, ok := peer.FromContext(ctx); ok {
+		if authInfo, ok := p.AuthInfo.(fmt.Stringer); ok {
+			e.Peer = authInfo.String()
+		}
+	}
+	if err != nil {
+		e.Outcome = OutcomeFailure
+		e.Error = err.Error()
+	}
+	if err := s.audit.Record(e); err != nil {
+		zap.L().Error("audit: unable to record entry", zap.String("op", op), zap.String("uid", uid), zap.Error(err))
+	}
+}
@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	// chainBreakOperation records a broken hash chain found when opening a file target.
+	chainBreakOperation = "chain-break"
+	// brokenTimeFormat timestamps the audit logs moved aside after a failed verification.
+	brokenTimeFormat = "20060102T150405Z"
+)
+
+// Options configures the audit sink.
+type Options struct {
+	// Target is one of file:///path (or a bare path), syslog:// or unix:///path.
+	// An empty Target disables the audit log.
+	Target string
+	// MaxSizeMB and MaxBackups control the rotation of a file target.
+	MaxSizeMB  int
+	MaxBackups int
+}
+
+// Open returns the audit Logger writing to opts.Target, or nil when the audit log is disabled.
+func Open(opts Options) (*Logger, error) {
+	if len(opts.Target) == 0 {
+		return nil, nil
+	}
+
+	target, err := url.Parse(opts.Target)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ invalid audit target %q, error: %v", opts.Target, err)
+	}
+
+	switch target.Scheme {
+	case "", "file":
+		return openFile(target.Path, opts)
+	case "syslog":
+		sink, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTHPRIV, "kleidi-audit")
+		if err != nil {
+			return nil, err
+		}
+		return NewLogger(sink, ""), nil
+	case "unix":
+		conn, err := net.Dial("unix", target.Path)
+		if err != nil {
+			return nil, err
+		}
+		return NewLogger(conn, ""), nil
+	default:
+		return nil, fmt.Errorf("/!\\ audit target scheme %q is not supported. Only file, syslog and unix are valid options", target.Scheme)
+	}
+}
+
+// openFile opens a rotated file sink, continuing the hash chain of its current content.
+func openFile(path string, opts Options) (*Logger, error) {
+	if len(path) == 0 {
+		return nil, errors.New("/!\\ audit file path can not be an empty string")
+	}
+
+	prevHash, broken, err := verifyFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sink io.WriteCloser = &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    opts.MaxSizeMB,
+		MaxBackups: opts.MaxBackups,
+	}
+	l := NewLogger(sink, prevHash)
+	if len(broken) != 0 {
+		zap.L().Error("audit: hash chain broken, recording the break", zap.String("path", path), zap.String("reason", broken))
+		if err := l.Record(Entry{Operation: chainBreakOperation, Outcome: OutcomeFailure, Error: broken}); err != nil {
+			sink.Close()
+			return nil, fmt.Errorf("/!\\ unable to record the audit chain break: %v", err)
+		}
+	}
+	zap.L().Info("INFO: audit log enabled", zap.String("path", path))
+	return l, nil
+}
+
+// verifyFile verifies the audit log at path and returns the hash its chain continues
+// from. A file failing verification is moved aside, and a file missing while rotated
+// files remain continues the chain of the last rotated file: both return the reason
+// of the broken chain, to be recorded as the first entry of the new file.
+func verifyFile(path string) (prevHash, broken string, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		backup, err := lastBackup(path)
+		if err != nil || len(backup) == 0 {
+			return "", "", err
+		}
+		if f, err = os.Open(backup); err != nil {
+			return "", "", err
+		}
+		defer f.Close()
+		prevHash, err = Verify(f)
+		if err != nil {
+			return prevHash, fmt.Sprintf("audit log missing, rotated file %s failed verification: %v", backup, err), nil
+		}
+		return prevHash, "audit log missing, continuing the chain of the rotated file " + backup, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	prevHash, verifyErr := Verify(f)
+	f.Close()
+	if verifyErr == nil {
+		return prevHash, "", nil
+	}
+	aside := fmt.Sprintf("%s.broken-%s", path, time.Now().UTC().Format(brokenTimeFormat))
+	if err := os.Rename(path, aside); err != nil {
+		return "", "", fmt.Errorf("/!\\ unable to move aside the audit log failing verification: %v", err)
+	}
+	return prevHash, fmt.Sprintf("audit log failed verification, moved to %s: %v", aside, verifyErr), nil
+}
+
+// lastBackup returns the most recent file rotated from path, if any. The rotated files
+// are named after path with a sortable timestamp inserted before the extension.
+func lastBackup(path string) (string, error) {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(filepath.Base(path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	last := ""
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ext) && name > last {
+			last = name
+		}
+	}
+	if len(last) == 0 {
+		return "", nil
+	}
+	return filepath.Join(filepath.Dir(path), last), nil
+}
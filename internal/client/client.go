@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	kmsapi "k8s.io/kms/apis/v2"
+)
+
+// Client talks to a running kleidi plugin over its unix socket, as the API server does.
+type Client struct {
+	conn *grpc.ClientConn
+	kms  kmsapi.KeyManagementServiceClient
+}
+
+// New returns a Client for the unix socket addr, as returned by utils.ValidateListenAddr.
+func New(addr string) (*Client, error) {
+	conn, err := grpc.NewClient("passthrough:///kleidi",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		conn: conn,
+		kms:  kmsapi.NewKeyManagementServiceClient(conn),
+	}, nil
+}
+
+// Close closes the connection to the plugin.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) Status(ctx context.Context) (*kmsapi.StatusResponse, error) {
+	return c.kms.Status(ctx, &kmsapi.StatusRequest{})
+}
+
+func (c *Client) Encrypt(ctx context.Context, uid string, plaintext []byte) (*kmsapi.EncryptResponse, error) {
+	return c.kms.Encrypt(ctx, &kmsapi.EncryptRequest{
+		Uid:       uid,
+		Plaintext: plaintext,
+	})
+}
+
+func (c *Client) Decrypt(ctx context.Context, uid, keyID string, ciphertext []byte, annotations map[string][]byte) (*kmsapi.DecryptResponse, error) {
+	return c.kms.Decrypt(ctx, &kmsapi.DecryptRequest{
+		Uid:         uid,
+		KeyId:       keyID,
+		Ciphertext:  ciphertext,
+		Annotations: annotations,
+	})
+}
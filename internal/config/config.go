@@ -0,0 +1,254 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	APIVersion = "kleidi.beezy.dev/v1alpha1"
+	Kind       = "KleidiConfig"
+
+	// envPrefix prefixes the environment variables overriding the config document.
+	envPrefix = "KLEIDI_"
+)
+
+// Config is the versioned kleidi configuration document, in YAML or JSON.
+type Config struct {
+	APIVersion   string       `json:"apiVersion"`
+	Kind         string       `json:"kind"`
+	Server       Server       `json:"server"`
+	Logging      Logging      `json:"logging"`
+	Metrics      Listener     `json:"metrics"`
+	Health       Listener     `json:"health"`
+	Tracing      Tracing      `json:"tracing"`
+	Audit        Audit        `json:"audit"`
+	DecryptCache DecryptCache `json:"decryptCache"`
+	Provider     Provider     `json:"provider"`
+}
+
+type Server struct {
+	// Listen is the unix socket of the KMS gRPC server, e.g. unix:///tmp/kleidi/kleidi-kms-plugin.socket.
+	Listen string `json:"listen"`
+	// SocketMode is the octal file mode of the unix socket, e.g. "0600".
+	SocketMode string `json:"socketMode"`
+	// SocketOwner and SocketGroup are the user and group, name or numeric id, owning
+	// the unix socket. The socket is owned by the kleidi process when empty.
+	SocketOwner string `json:"socketOwner"`
+	SocketGroup string `json:"socketGroup"`
+	// AllowedPeers restricts the processes allowed to connect to the unix socket.
+	AllowedPeers AllowedPeers `json:"allowedPeers"`
+	// DrainTimeout is the period, e.g. "10s", given to the in-flight requests to complete on shutdown.
+	DrainTimeout string `json:"drainTimeout"`
+}
+
+// AllowedPeers lists the peers allowed by their SO_PEERCRED credentials. A peer is
+// allowed when its UID, its GID or its executable path is listed. Any peer is allowed
+// when the lists are empty.
+type AllowedPeers struct {
+	UIDs        []uint32 `json:"uids,omitempty"`
+	GIDs        []uint32 `json:"gids,omitempty"`
+	Executables []string `json:"executables,omitempty"`
+}
+
+type Logging struct {
+	Level    string `json:"level"`
+	Format   string `json:"format"`
+	Sampling bool   `json:"sampling"`
+}
+
+type Listener struct {
+	// Listen is the HTTP listen address, the listener is disabled when empty.
+	Listen string `json:"listen"`
+}
+
+type Tracing struct {
+	// OTLPEndpoint is the OTLP/gRPC collector endpoint, tracing is disabled when empty.
+	OTLPEndpoint string `json:"otlpEndpoint"`
+}
+
+type Audit struct {
+	// Target is file:///path, syslog:// or unix:///path, the audit log is disabled when empty.
+	Target     string `json:"target"`
+	MaxSizeMB  int    `json:"maxSizeMB"`
+	MaxBackups int    `json:"maxBackups"`
+}
+
+// DecryptCache is the in-memory cache of the Decrypt results, in front of the provider.
+type DecryptCache struct {
+	// MaxEntries bounds the number of cached plaintexts, the cache is disabled when 0.
+	MaxEntries int `json:"maxEntries"`
+	// TTL is the period, e.g. "5m", a plaintext is served from the cache after its decryption.
+	TTL string `json:"ttl"`
+}
+
+type Provider struct {
+	// Name is one of the providers accepted by utils.ValidateProvider.
+	Name string `json:"name"`
+	// ConfigFile is the path of the provider configuration file.
+	ConfigFile string `json:"configFile"`
+	// Config is the provider configuration set inline, used instead of ConfigFile when set.
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// Default returns the configuration matching the historical flag defaults.
+func Default() *Config {
+	return &Config{
+		APIVersion: APIVersion,
+		Kind:       Kind,
+		Server: Server{
+			Listen:       "unix:///tmp/kleidi/kleidi-kms-plugin.socket",
+			SocketMode:   "0600",
+			DrainTimeout: "10s",
+		},
+		Logging: Logging{
+			Level:  "info",
+			Format: "console",
+		},
+		Audit: Audit{
+			MaxSizeMB:  100,
+			MaxBackups: 10,
+		},
+		DecryptCache: DecryptCache{
+			TTL: "5m",
+		},
+		Provider: Provider{
+			Name:       "softhsm",
+			ConfigFile: "/opt/kleidi/config.json",
+		},
+	}
+}
+
+// Load returns the default configuration overlaid with the document at path, if any.
+// Unknown fields are rejected.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if len(path) == 0 {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read config file: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid config file %s: %v", path, err)
+	}
+	if cfg.APIVersion != APIVersion || cfg.Kind != Kind {
+		return nil, fmt.Errorf("/!\\ invalid config file %s: expecting apiVersion %s and kind %s, got %q and %q",
+			path, APIVersion, Kind, cfg.APIVersion, cfg.Kind)
+	}
+	return cfg, nil
+}
+
+// setters maps the flag names to the fields they override. The same keys, upper-cased
+// with "-" replaced by "_" and prefixed by KLEIDI_, are the environment overrides.
+var setters = map[string]func(c *Config, value string) error{
+	"listen":              func(c *Config, v string) error { c.Server.Listen = v; return nil },
+	"socket-mode":         func(c *Config, v string) error { c.Server.SocketMode = v; return nil },
+	"socket-owner":        func(c *Config, v string) error { c.Server.SocketOwner = v; return nil },
+	"socket-group":        func(c *Config, v string) error { c.Server.SocketGroup = v; return nil },
+	"allowed-uids":        func(c *Config, v string) error { return setIDs(&c.Server.AllowedPeers.UIDs, v) },
+	"allowed-gids":        func(c *Config, v string) error { return setIDs(&c.Server.AllowedPeers.GIDs, v) },
+	"allowed-executables": func(c *Config, v string) error { c.Server.AllowedPeers.Executables = splitList(v); return nil },
+	"drain-timeout":       func(c *Config, v string) error { return setDuration(&c.Server.DrainTimeout, v) },
+	"provider":            func(c *Config, v string) error { c.Provider.Name = v; return nil },
+	"configfile":          func(c *Config, v string) error { c.Provider.ConfigFile = v; c.Provider.Config = nil; return nil },
+	"log-level":           func(c *Config, v string) error { c.Logging.Level = v; return nil },
+	"log-format":          func(c *Config, v string) error { c.Logging.Format = v; return nil },
+	"log-sampling":        func(c *Config, v string) error { return setBool(&c.Logging.Sampling, v) },
+	"metrics-listen":      func(c *Config, v string) error { c.Metrics.Listen = v; return nil },
+	"health-listen":       func(c *Config, v string) error { c.Health.Listen = v; return nil },
+	"otlp-endpoint":       func(c *Config, v string) error { c.Tracing.OTLPEndpoint = v; return nil },
+	"audit-log":           func(c *Config, v string) error { c.Audit.Target = v; return nil },
+	"audit-max-size":      func(c *Config, v string) error { return setInt(&c.Audit.MaxSizeMB, v) },
+	"audit-max-backups":   func(c *Config, v string) error { return setInt(&c.Audit.MaxBackups, v) },
+	"decrypt-cache-size":  func(c *Config, v string) error { return setInt(&c.DecryptCache.MaxEntries, v) },
+	"decrypt-cache-ttl":   func(c *Config, v string) error { return setDuration(&c.DecryptCache.TTL, v) },
+}
+
+// Set overrides the field bound to the flag name with value.
+func (c *Config) Set(name, value string) error {
+	set, ok := setters[name]
+	if !ok {
+		return fmt.Errorf("/!\\ unknown setting %q", name)
+	}
+	if err := set(c, value); err != nil {
+		return fmt.Errorf("/!\\ invalid value %q for %s: %v", value, name, err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides the fields with the KLEIDI_* environment variables which are set.
+func (c *Config) ApplyEnv() error {
+	for name := range setters {
+		env := EnvName(name)
+		if value, ok := os.LookupEnv(env); ok {
+			if err := c.Set(name, value); err != nil {
+				return fmt.Errorf("%v (env %s)", err, env)
+			}
+		}
+	}
+	return nil
+}
+
+// EnvName returns the environment variable overriding the flag name.
+func EnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func setBool(field *bool, value string) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*field = b
+	return nil
+}
+
+func setDuration(field *string, value string) error {
+	if _, err := time.ParseDuration(value); err != nil {
+		return err
+	}
+	*field = value
+	return nil
+}
+
+func setInt(field *int, value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*field = i
+	return nil
+}
+
+// splitList splits a comma-separated list, ignoring the empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) != 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func setIDs(field *[]uint32, value string) error {
+	var ids []uint32
+	for _, item := range splitList(value) {
+		id, err := strconv.ParseUint(item, 10, 32)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, uint32(id))
+	}
+	*field = ids
+	return nil
+}
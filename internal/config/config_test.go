@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kleidi.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		expectErr string
+	}{
+		{
+			name: "YAML document",
+			input: `apiVersion: kleidi.beezy.dev/v1alpha1
+kind: KleidiConfig
+logging:
+  format: json
+provider:
+  name: hvault
+  config:
+    transitkey: kleidi
+`,
+		},
+		{
+			name:  "JSON document",
+			input: `{"apiVersion": "kleidi.beezy.dev/v1alpha1", "kind": "KleidiConfig", "logging": {"format": "json"}, "provider": {"name": "hvault", "config": {"transitkey": "kleidi"}}}`,
+		},
+		{
+			name: "Unknown field",
+			input: `apiVersion: kleidi.beezy.dev/v1alpha1
+kind: KleidiConfig
+logging:
+  formatt: json
+`,
+			expectErr: `unknown field "formatt"`,
+		},
+		{
+			name: "Wrong kind",
+			input: `apiVersion: kleidi.beezy.dev/v1alpha1
+kind: EncryptionConfiguration
+`,
+			expectErr: "expecting apiVersion",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := Load(writeConfig(t, tc.input))
+			if len(tc.expectErr) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected an error containing %q, but got: %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if cfg.Logging.Format != "json" || cfg.Provider.Name != "hvault" {
+				t.Errorf("document not applied: %+v", cfg)
+			}
+			if cfg.Logging.Level != "info" || cfg.Server.Listen != Default().Server.Listen {
+				t.Errorf("defaults not kept: %+v", cfg)
+			}
+			if string(cfg.Provider.Config) != `{"transitkey":"kleidi"}` {
+				t.Errorf("expected inline provider config, but got %s", cfg.Provider.Config)
+			}
+		})
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `apiVersion: kleidi.beezy.dev/v1alpha1
+kind: KleidiConfig
+logging:
+  level: warn
+  format: json
+provider:
+  name: hvault
+  config:
+    transitkey: kleidi
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("KLEIDI_LOG_LEVEL", "error")
+	t.Setenv("KLEIDI_AUDIT_MAX_SIZE", "42")
+	t.Setenv("KLEIDI_DECRYPT_CACHE_SIZE", "1000")
+	t.Setenv("KLEIDI_CONFIGFILE", "/etc/kleidi/provider.json")
+	if err := cfg.ApplyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Logging.Level != "error" || cfg.Audit.MaxSizeMB != 42 || cfg.DecryptCache.MaxEntries != 1000 {
+		t.Errorf("environment not applied: %+v", cfg)
+	}
+	if cfg.Provider.ConfigFile != "/etc/kleidi/provider.json" || cfg.Provider.Config != nil {
+		t.Errorf("KLEIDI_CONFIGFILE should replace the inline provider config: %+v", cfg.Provider)
+	}
+
+	// flags are applied last.
+	if err := cfg.Set("log-level", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Logging.Level != "debug" || cfg.Logging.Format != "json" {
+		t.Errorf("flag not applied: %+v", cfg.Logging)
+	}
+
+	if err := cfg.Set("decrypt-cache-ttl", "1 hour"); err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+
+	t.Setenv("KLEIDI_LOG_SAMPLING", "maybe")
+	if err := cfg.ApplyEnv(); err == nil || !strings.Contains(err.Error(), "KLEIDI_LOG_SAMPLING") {
+		t.Errorf("expected an error naming KLEIDI_LOG_SAMPLING, but got: %v", err)
+	}
+}
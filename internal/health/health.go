@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	healthOK = "ok"
+
+	readyTimeOut      = 5 * time.Second
+	readHeaderTimeOut = 5 * time.Second
+)
+
+var (
+	mu     sync.RWMutex
+	socket string
+	kms    service.Service
+)
+
+// Register records the KMS socket and the provider service once the gRPC server is started.
+// Until then /readyz reports the plugin as not ready.
+func Register(addr string, svc service.Service) {
+	mu.Lock()
+	defer mu.Unlock()
+	socket = addr
+	kms = svc
+}
+
+func registered() (string, service.Service) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return socket, kms
+}
+
+// Livez reports the process and, once registered, the KMS socket as alive.
+func Livez(w http.ResponseWriter, r *http.Request) {
+	addr, _ := registered()
+	// abstract sockets have no file on disk.
+	if len(addr) != 0 && !strings.HasPrefix(addr, "@") {
+		if _, err := os.Stat(addr); err != nil {
+			zap.L().Warn("livez: socket unavailable", zap.String("socket", addr), zap.Error(err))
+			http.Error(w, "socket unavailable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.Write([]byte(healthOK))
+}
+
+// Readyz reports the plugin as ready when the provider Status is healthy, which
+// includes the token validity and the backend reachability for remote providers.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	_, svc := registered()
+	if svc == nil {
+		http.Error(w, "provider not started", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeOut)
+	defer cancel()
+
+	status, err := svc.Status(ctx)
+	if err != nil {
+		zap.L().Warn("readyz: provider status failed", zap.Error(err))
+		http.Error(w, "provider status failed: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if status.Healthz != healthOK {
+		http.Error(w, "provider unhealthy: "+status.Healthz, http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte(healthOK))
+}
+
+// Serve starts the HTTP listener exposing /livez, /readyz and /loglevel on addr. It is a no-op when addr is empty.
+func Serve(addr string) {
+	if len(addr) == 0 {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", Livez)
+	mux.HandleFunc("/readyz", Readyz)
+	mux.Handle("/loglevel", logger.Handler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeOut,
+	}
+
+	zap.L().Info("INFO: health endpoints listening", zap.String("addr", addr), zap.Strings("paths", []string{"/livez", "/readyz", "/loglevel"}))
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Fatal("EXIT: health endpoints failed", zap.String("addr", addr), zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/kms/pkg/service"
+)
+
+type fakeService struct {
+	healthz string
+	err     error
+}
+
+func (f *fakeService) Encrypt(ctx context.Context, uid string, data []byte) (*service.EncryptResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	return nil, nil
+}
+
+func (f *fakeService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	return &service.StatusResponse{Version: "v2", Healthz: f.healthz}, f.err
+}
+
+func TestReadyz(t *testing.T) {
+	testCases := []struct {
+		name string
+		svc  service.Service
+		code int
+	}{
+		{name: "not registered", svc: nil, code: http.StatusServiceUnavailable},
+		{name: "healthy", svc: &fakeService{healthz: "ok"}, code: http.StatusOK},
+		{name: "unhealthy", svc: &fakeService{healthz: "nok"}, code: http.StatusServiceUnavailable},
+		{name: "status error", svc: &fakeService{healthz: "nok", err: errors.New("sealed")}, code: http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			Register("", tc.svc)
+			rec := httptest.NewRecorder()
+			Readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tc.code {
+				t.Errorf("expected status code %d, but got %d", tc.code, rec.Code)
+			}
+		})
+	}
+}
+
+func TestLivez(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "kleidi.socket")
+
+	Register(sock, nil)
+	rec := httptest.NewRecorder()
+	Livez(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status code %d with a missing socket, but got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	if err := os.WriteFile(sock, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	Livez(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status code %d with an existing socket, but got %d", http.StatusOK, rec.Code)
+	}
+
+	Register("@kleidi", nil)
+	rec = httptest.NewRecorder()
+	Livez(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status code %d with an abstract socket, but got %d", http.StatusOK, rec.Code)
+	}
+}
@@ -0,0 +1,30 @@
+package logger
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Structured field helpers shared by every provider, so that the same keys are
+// used whatever the code path emitting the entry.
+
+func Provider(name string) zap.Field {
+	return zap.String("provider", name)
+}
+
+func UID(uid string) zap.Field {
+	return zap.String("uid", uid)
+}
+
+func KeyID(keyID string) zap.Field {
+	return zap.String("keyID", keyID)
+}
+
+func Op(op string) zap.Field {
+	return zap.String("op", op)
+}
+
+func Duration(d time.Duration) zap.Field {
+	return zap.Duration("duration", d)
+}
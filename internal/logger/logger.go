@@ -1,28 +1,71 @@
 package logger
 
 import (
-	//"os"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-func CreateLogger(debug bool) *zap.Logger {
+const (
+	// samplingInitial and samplingThereafter bound the identical entries logged
+	// per second once the sampling is enabled, e.g. the per request debug lines.
+	samplingInitial    = 100
+	samplingThereafter = 100
+)
+
+// Options configures the logger built by CreateLogger.
+type Options struct {
+	// Level is the minimum enabled level: debug, info, warn or error.
+	Level string
+	// Encoding is either "console" or "json".
+	Encoding string
+	// Sampling enables the zap sampling of repeated entries.
+	Sampling bool
+}
+
+var (
+	atomicLevel = zap.NewAtomicLevel()
+	baseLevel   = zapcore.InfoLevel
+)
+
+func CreateLogger(opts Options) (*zap.Logger, error) {
 	encoderCfg := zap.NewProductionEncoderConfig()
 	encoderCfg.TimeKey = "timestamp"
 	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
-	level := zap.InfoLevel
 
-	if debug {
-		level = zap.DebugLevel
+	level, err := zapcore.ParseLevel(opts.Level)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ invalid log level %q", opts.Level)
+	}
+	baseLevel = level
+	atomicLevel.SetLevel(level)
+
+	switch opts.Encoding {
+	case "console", "json":
+	default:
+		return nil, fmt.Errorf("/!\\ invalid log encoding %q, only console and json are valid options", opts.Encoding)
 	}
+
+	var sampling *zap.SamplingConfig
+	if opts.Sampling {
+		sampling = &zap.SamplingConfig{
+			Initial:    samplingInitial,
+			Thereafter: samplingThereafter,
+		}
+	}
+
 	config := zap.Config{
-		Level:             zap.NewAtomicLevelAt(level),
-		Development:       debug,
+		Level:             atomicLevel,
+		Development:       level == zapcore.DebugLevel,
 		DisableCaller:     false,
 		DisableStacktrace: false,
-		Sampling:          nil,
-		Encoding:          "console",
+		Sampling:          sampling,
+		Encoding:          opts.Encoding,
 		EncoderConfig:     encoderCfg,
 		OutputPaths: []string{
 			"stderr",
@@ -31,9 +74,32 @@ func CreateLogger(debug bool) *zap.Logger {
 			"stderr",
 		},
 		InitialFields: map[string]interface{}{
-			//"pid": os.Getpid(),
+			"pid": os.Getpid(),
 		},
 	}
 
-	return zap.Must(config.Build())
+	return config.Build()
+}
+
+// Handler returns the HTTP handler reading (GET) and changing (PUT) the log level at runtime.
+func Handler() http.Handler {
+	return atomicLevel
+}
+
+// WatchLevelSignal toggles the log level between debug and the configured level
+// each time the process receives SIGUSR1.
+func WatchLevelSignal() {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGUSR1)
+
+	go func() {
+		for range signalChan {
+			level := zapcore.DebugLevel
+			if atomicLevel.Level() == zapcore.DebugLevel {
+				level = baseLevel
+			}
+			atomicLevel.SetLevel(level)
+			zap.L().Info("INFO: log level changed on SIGUSR1", zap.Stringer("level", level))
+		}
+	}()
 }
@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+var _ service.Service = &loggedService{}
+
+// loggedService logs every call made to the wrapped provider with the
+// structured provider, op, uid, keyID and duration fields.
+type loggedService struct {
+	provider string
+	next     service.Service
+}
+
+// NewLoggedService wraps next so that each KMS operation is logged, at debug level
+// when it succeeds and at error level when it fails.
+func NewLoggedService(provider string, next service.Service) service.Service {
+	return &loggedService{
+		provider: provider,
+		next:     next,
+	}
+}
+
+func (s *loggedService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	start := time.Now()
+	resp, err := s.next.Encrypt(ctx, uid, plaintext)
+	keyID := ""
+	if resp != nil {
+		keyID = resp.KeyID
+	}
+	s.log("encrypt", start, err, UID(uid), KeyID(keyID))
+	return resp, err
+}
+
+func (s *loggedService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	start := time.Now()
+	plaintext, err := s.next.Decrypt(ctx, uid, req)
+	s.log("decrypt", start, err, UID(uid), KeyID(req.KeyID))
+	return plaintext, err
+}
+
+func (s *loggedService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	start := time.Now()
+	resp, err := s.next.Status(ctx)
+	fields := []zap.Field{}
+	if resp != nil {
+		fields = append(fields, KeyID(resp.KeyID), zap.String("healthz", resp.Healthz))
+	}
+	s.log("status", start, err, fields...)
+	return resp, err
+}
+
+func (s *loggedService) log(op string, start time.Time, err error, fields ...zap.Field) {
+	fields = append(fields, Provider(s.provider), Op(op), Duration(time.Since(start)))
+	if err != nil {
+		zap.L().Error("kms operation failed", append(fields, zap.Error(err))...)
+		return
+	}
+	zap.L().Debug("kms operation", fields...)
+}
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	namespace = "kleidi"
+
+	readHeaderTimeOut = 5 * time.Second
+)
+
+// Registry holds every kleidi collector. A dedicated registry is used instead of
+// the global default one to keep the exposition limited to what kleidi owns.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Requests counts the KMS operations by provider, operation and gRPC result code.
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kms_requests_total",
+		Help:      "Total number of KMS operations by provider, operation and result code.",
+	}, []string{"provider", "operation", "code"})
+
+	// RequestDuration tracks the latency of the KMS operations.
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kms_request_duration_seconds",
+		Help:      "Latency of KMS operations by provider, operation and result code.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"provider", "operation", "code"})
+
+	// InFlight reports the KMS operations currently being processed.
+	InFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kms_requests_in_flight",
+		Help:      "Number of KMS operations currently being processed.",
+	}, []string{"provider", "operation"})
+
+	// BackendRetries counts the retried calls against a remote backend.
+	BackendRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_retries_total",
+		Help:      "Total number of retried calls against the remote backend.",
+	}, []string{"provider"})
+
+	// TokenTTL reports the remaining time to live of the backend token.
+	TokenTTL = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backend_token_ttl_seconds",
+		Help:      "Remaining time to live of the backend authentication token.",
+	}, []string{"provider"})
+
+	// KeyInfo exposes the current KeyID reported to the API server.
+	KeyInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "key_id_info",
+		Help:      "Current KeyID reported by the provider, value is always 1.",
+	}, []string{"provider", "key_id"})
+
+	// ConfigReloads counts the provider configuration reloads by result.
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Total number of provider configuration reloads by result: success, rollback or failure.",
+	}, []string{"provider", "result"})
+
+	// SocketRecreations counts the recreations of the KMS socket listener.
+	SocketRecreations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "socket_recreations_total",
+		Help:      "Total number of KMS socket listener recreations by reason (removed, replaced) and result.",
+	}, []string{"reason", "result"})
+
+	// DecryptCacheRequests counts the Decrypt calls served by the decrypt cache, by result.
+	DecryptCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decrypt_cache_requests_total",
+		Help:      "Total number of Decrypt calls looked up in the decrypt cache by result: hit or miss.",
+	}, []string{"provider", "result"})
+
+	// DecryptCacheEvictions counts the plaintexts zeroed and dropped from the decrypt cache.
+	DecryptCacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decrypt_cache_evictions_total",
+		Help:      "Total number of plaintexts evicted from the decrypt cache by reason: expired, capacity or purged.",
+	}, []string{"provider", "reason"})
+
+	// DecryptCacheEntries reports the plaintexts currently held by the decrypt cache.
+	DecryptCacheEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "decrypt_cache_entries",
+		Help:      "Number of plaintexts currently held by the decrypt cache.",
+	}, []string{"provider"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Requests,
+		RequestDuration,
+		InFlight,
+		BackendRetries,
+		TokenTTL,
+		KeyInfo,
+		ConfigReloads,
+		SocketRecreations,
+		DecryptCacheRequests,
+		DecryptCacheEvictions,
+		DecryptCacheEntries,
+	)
+}
+
+// SetKeyID records keyID as the current KeyID of provider, dropping the previous one.
+func SetKeyID(provider, keyID string) {
+	if len(keyID) == 0 {
+		return
+	}
+	KeyInfo.DeletePartialMatch(prometheus.Labels{"provider": provider})
+	KeyInfo.WithLabelValues(provider, keyID).Set(1)
+}
+
+// Handler returns the HTTP handler exposing the kleidi registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Serve starts the HTTP listener exposing /metrics on addr. It is a no-op when addr is empty.
+func Serve(addr string) {
+	if len(addr) == 0 {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeOut,
+	}
+
+	zap.L().Info("INFO: metrics endpoint listening", zap.String("addr", addr), zap.String("path", "/metrics"))
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Fatal("EXIT: metrics endpoint failed", zap.String("addr", addr), zap.Error(err))
+		}
+	}()
+}
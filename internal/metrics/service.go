@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/status"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	opEncrypt = "encrypt"
+	opDecrypt = "decrypt"
+	opStatus  = "status"
+)
+
+var _ service.Service = &instrumentedService{}
+
+// instrumentedService records the Prometheus metrics of every call made
+// to the wrapped provider.
+type instrumentedService struct {
+	provider string
+	next     service.Service
+}
+
+// NewInstrumentedService wraps next so that each KMS operation is counted and timed.
+func NewInstrumentedService(provider string, next service.Service) service.Service {
+	return &instrumentedService{
+		provider: provider,
+		next:     next,
+	}
+}
+
+func (s *instrumentedService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	done := s.observe(opEncrypt)
+	resp, err := s.next.Encrypt(ctx, uid, plaintext)
+	done(err)
+	if err == nil {
+		SetKeyID(s.provider, resp.KeyID)
+	}
+	return resp, err
+}
+
+func (s *instrumentedService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	done := s.observe(opDecrypt)
+	plaintext, err := s.next.Decrypt(ctx, uid, req)
+	done(err)
+	return plaintext, err
+}
+
+func (s *instrumentedService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	done := s.observe(opStatus)
+	resp, err := s.next.Status(ctx)
+	done(err)
+	if resp != nil {
+		SetKeyID(s.provider, resp.KeyID)
+	}
+	return resp, err
+}
+
+// observe marks the start of operation op and returns the function recording its outcome.
+func (s *instrumentedService) observe(op string) func(error) {
+	start := time.Now()
+	inFlight := InFlight.WithLabelValues(s.provider, op)
+	inFlight.Inc()
+
+	return func(err error) {
+		inFlight.Dec()
+		code := status.Code(err).String()
+		Requests.WithLabelValues(s.provider, op, code).Inc()
+		RequestDuration.WithLabelValues(s.provider, op, code).Observe(time.Since(start).Seconds())
+	}
+}
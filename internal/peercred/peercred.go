@@ -0,0 +1,126 @@
+package peercred
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/beezy-dev/kleidi/internal/audit"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+)
+
+// Cred is the identity of the process connected to the unix socket, read with SO_PEERCRED.
+type Cred struct {
+	PID int32
+	UID uint32
+	GID uint32
+	// Exe is the executable path of the process, empty when it can not be read,
+	// e.g. from another PID namespace.
+	Exe string
+}
+
+func (c Cred) String() string {
+	return fmt.Sprintf("pid=%d uid=%d gid=%d exe=%s", c.PID, c.UID, c.GID, c.Exe)
+}
+
+// Policy lists the peers allowed to connect. A peer is allowed when its UID, its GID
+// or its executable path is listed. The policy is disabled when all lists are empty.
+type Policy struct {
+	UIDs        []uint32
+	GIDs        []uint32
+	Executables []string
+}
+
+// Enabled reports whether the policy restricts the peers.
+func (p Policy) Enabled() bool {
+	return len(p.UIDs) != 0 || len(p.GIDs) != 0 || len(p.Executables) != 0
+}
+
+// Allow reports whether the peer c is allowed by the policy.
+func (p Policy) Allow(c Cred) bool {
+	return slices.Contains(p.UIDs, c.UID) ||
+		slices.Contains(p.GIDs, c.GID) ||
+		(len(c.Exe) != 0 && slices.Contains(p.Executables, c.Exe))
+}
+
+// AuthInfo carries the peer credentials of an authorized connection, available
+// to the KMS calls with peer.FromContext.
+type AuthInfo struct {
+	credentials.CommonAuthInfo
+	Cred Cred
+}
+
+func (AuthInfo) AuthType() string { return "peercred" }
+
+func (a AuthInfo) String() string { return a.Cred.String() }
+
+// transportCredentials authorizes the connections on the unix socket against a policy.
+// It does not encrypt the connection, the socket being local.
+type transportCredentials struct {
+	policy   Policy
+	provider string
+	audit    *audit.Logger
+}
+
+// NewTransportCredentials returns the gRPC server credentials rejecting the peers not
+// allowed by policy. The rejections are recorded in audit, which can be nil.
+func NewTransportCredentials(policy Policy, provider string, audit *audit.Logger) credentials.TransportCredentials {
+	return &transportCredentials{
+		policy:   policy,
+		provider: provider,
+		audit:    audit,
+	}
+}
+
+func (t *transportCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cred, err := ReadCred(conn)
+	if err != nil {
+		t.deny(cred, err)
+		return nil, nil, err
+	}
+	if !t.policy.Allow(cred) {
+		err := errors.New("peer not allowed")
+		t.deny(cred, err)
+		return nil, nil, err
+	}
+	return conn, AuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity},
+		Cred:           cred,
+	}, nil
+}
+
+func (t *transportCredentials) deny(cred Cred, err error) {
+	zap.L().Warn("connection rejected", zap.Stringer("peer", cred), zap.Error(err))
+	e := audit.Entry{
+		Timestamp: time.Now().UTC(),
+		Operation: "connect",
+		Provider:  t.provider,
+		Outcome:   audit.OutcomeDenied,
+		Error:     err.Error(),
+		Peer:      cred.String(),
+	}
+	if err := t.audit.Record(e); err != nil {
+		zap.L().Error("audit: unable to record entry", zap.String("op", e.Operation), zap.Error(err))
+	}
+}
+
+func (t *transportCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("peercred: server side credentials only")
+}
+
+func (t *transportCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+func (t *transportCredentials) Clone() credentials.TransportCredentials {
+	clone := *t
+	return &clone
+}
+
+func (t *transportCredentials) OverrideServerName(string) error {
+	return nil
+}
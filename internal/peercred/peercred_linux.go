@@ -0,0 +1,38 @@
+package peercred
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// ReadCred returns the credentials of the process connected to the unix socket conn.
+func ReadCred(conn net.Conn) (Cred, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return Cred{}, errors.New("not a unix socket connection")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return Cred{}, err
+	}
+
+	var (
+		ucred  *syscall.Ucred
+		optErr error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		ucred, optErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return Cred{}, err
+	}
+	if optErr != nil {
+		return Cred{}, fmt.Errorf("SO_PEERCRED: %v", optErr)
+	}
+
+	cred := Cred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}
+	cred.Exe, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", ucred.Pid))
+	return cred, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package peercred
+
+import (
+	"errors"
+	"net"
+)
+
+// ReadCred is only supported on Linux, where SO_PEERCRED is available.
+func ReadCred(conn net.Conn) (Cred, error) {
+	return Cred{}, errors.New("peer credentials are only supported on linux")
+}
@@ -0,0 +1,62 @@
+package peercred
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyAllow(t *testing.T) {
+	cred := Cred{PID: 42, UID: 1000, GID: 1000, Exe: "/usr/local/bin/kube-apiserver"}
+	testCases := []struct {
+		name   string
+		policy Policy
+		allow  bool
+	}{
+		{name: "UID listed", policy: Policy{UIDs: []uint32{0, 1000}}, allow: true},
+		{name: "GID listed", policy: Policy{GIDs: []uint32{1000}}, allow: true},
+		{name: "Executable listed", policy: Policy{Executables: []string{"/usr/local/bin/kube-apiserver"}}, allow: true},
+		{name: "Nothing matching", policy: Policy{UIDs: []uint32{0}, Executables: []string{"/usr/bin/kube-apiserver"}}, allow: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if allow := tc.policy.Allow(cred); allow != tc.allow {
+				t.Fatalf("Allow(%s) = %v, want %v", cred, allow, tc.allow)
+			}
+		})
+	}
+}
+
+func TestReadCred(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "peercred.socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := net.Dial("unix", ln.Addr().String())
+		if err == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cred, err := ReadCred(conn)
+	if err != nil {
+		t.Fatalf("ReadCred() error: %v", err)
+	}
+	if int(cred.PID) != os.Getpid() || int(cred.UID) != os.Getuid() {
+		t.Fatalf("ReadCred() = %s, want pid=%d uid=%d", cred, os.Getpid(), os.Getuid())
+	}
+	if exe, _ := os.Executable(); cred.Exe != exe {
+		t.Fatalf("ReadCred() exe = %s, want %s", cred.Exe, exe)
+	}
+}
@@ -0,0 +1,59 @@
+package providers
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// The AES-GCM providers share the ciphertext layout of the PKCS#11 provider: the
+// random nonce followed by the sealed data, authenticated with the KeyID as
+// additional data, and the annotationKey annotation set to the layout version.
+
+const aeadLayoutVersion = "1"
+
+// sealAEAD returns nonce || Seal(plaintext) with keyID as additional data.
+func sealAEAD(aead cipher.AEAD, keyID string, plaintext []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	result := make([]byte, nonceSize+aead.Overhead()+len(plaintext))
+
+	n, err := rand.Read(result[:nonceSize])
+	if err != nil {
+		return nil, err
+	}
+
+	if n != nonceSize {
+		return nil, fmt.Errorf("/!\\ unable to read sufficient random bytes")
+	}
+
+	cipherText := aead.Seal(result[nonceSize:nonceSize], result[:nonceSize], plaintext, []byte(keyID))
+	return result[:nonceSize+len(cipherText)], nil
+}
+
+// openAEAD opens the output of sealAEAD.
+func openAEAD(aead cipher.AEAD, keyID string, data []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("/!\\ stored data was shorter than the required size")
+	}
+	return aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(keyID))
+}
+
+// aeadAnnotations returns the annotations of a ciphertext sealed by sealAEAD.
+func aeadAnnotations() map[string][]byte {
+	return map[string][]byte{
+		annotationKey: []byte(aeadLayoutVersion),
+	}
+}
+
+// checkAEADAnnotations checks the annotations of a ciphertext to open with openAEAD.
+func checkAEADAnnotations(annotations map[string][]byte) error {
+	if len(annotations) != 1 {
+		return fmt.Errorf("/!\\ invalid annotations")
+	}
+
+	if v, ok := annotations[annotationKey]; !ok || string(v) != aeadLayoutVersion {
+		return fmt.Errorf("/!\\ invalid version in annotations")
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"github.com/beezy-dev/kleidi/internal/tracing"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	awskmsProvider = "awskms"
+	// awskmsMaterialSeparator separates the key ARN from the current key material in the KeyID.
+	awskmsMaterialSeparator = "#"
+
+	awskmsTimeOut = 10 * time.Second
+)
+
+var _ service.Service = &awskmsRemoteService{}
+
+// awskmsConfig is the awskms provider configuration.
+type awskmsConfig struct {
+	// KeyARN is the ARN of the symmetric KMS key, or of an alias of it.
+	KeyARN string `json:"keyarn"`
+	// ClusterName is bound to the ciphertexts with the encryption context.
+	ClusterName string `json:"clustername"`
+	// Region defaults to the region of KeyARN.
+	Region string `json:"region"`
+	// Endpoint overrides the KMS endpoint, e.g. a LocalStack or moto server.
+	Endpoint string `json:"endpoint"`
+	// CredentialsFile and Profile select static credentials of a shared credentials
+	// file, instead of the default chain: environment, IRSA web identity and IMDS.
+	CredentialsFile string `json:"credentialsfile"`
+	Profile         string `json:"profile"`
+}
+
+func (c *awskmsConfig) validate() error {
+	if len(c.KeyARN) == 0 {
+		return errors.New("field \"keyarn\" is required")
+	}
+	keyARN, err := arn.Parse(c.KeyARN)
+	if err != nil || keyARN.Service != "kms" || !(strings.HasPrefix(keyARN.Resource, "key/") || strings.HasPrefix(keyARN.Resource, "alias/")) {
+		return fmt.Errorf("field \"keyarn\" must be a KMS key or alias ARN, got %q", c.KeyARN)
+	}
+	if len(c.ClusterName) == 0 {
+		return errors.New("field \"clustername\" is required")
+	}
+	if len(c.Region) == 0 {
+		c.Region = keyARN.Region
+	}
+	if len(c.CredentialsFile) != 0 {
+		if err := fileExists(c.CredentialsFile); err != nil {
+			return fmt.Errorf("field \"credentialsfile\": %v", err)
+		}
+	}
+	return nil
+}
+
+func readAWSKMSConfig(source ConfigSource) (*awskmsConfig, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read awskms config: %v", err)
+	}
+	config := &awskmsConfig{}
+	if err := decodeStrict(data, config, true); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid awskms config %s: %v", source, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid awskms config %s: %v", source, err)
+	}
+	return config, nil
+}
+
+// awskmsRemoteService wraps the data keys with the KMS Encrypt and Decrypt operations.
+// The KeyID is the key ARN followed by the current key material, so that a rotation
+// of the KMS key changes the KeyID reported to the API server.
+type awskmsRemoteService struct {
+	client            *kms.Client
+	keyARN            string
+	encryptionContext map[string]string
+
+	mu sync.RWMutex
+	// keyID is the resolved key ARN and its current key material.
+	keyID string
+}
+
+// NewAWSKMSRemoteService creates an awskms remote service, and describes the key to
+// resolve its ARN and check its state.
+func NewAWSKMSRemoteService(source ConfigSource) (service.Service, error) {
+	c, err := readAWSKMSConfig(source)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), awskmsTimeOut)
+	defer cancel()
+
+	opts := []func(*config.LoadOptions) error{config.WithRegion(c.Region)}
+	if len(c.CredentialsFile) != 0 {
+		opts = append(opts, config.WithSharedCredentialsFiles([]string{c.CredentialsFile}))
+	}
+	if len(c.Profile) != 0 {
+		opts = append(opts, config.WithSharedConfigProfile(c.Profile))
+	}
+	awsConfig, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ unable to load the AWS configuration: %v", err)
+	}
+
+	s := &awskmsRemoteService{
+		client: kms.NewFromConfig(awsConfig, func(o *kms.Options) {
+			if len(c.Endpoint) != 0 {
+				o.BaseEndpoint = aws.String(c.Endpoint)
+			}
+		}),
+		keyARN:            c.KeyARN,
+		encryptionContext: map[string]string{clusterBindingKey: c.ClusterName},
+	}
+	if _, err := s.describeKey(ctx); err != nil {
+		return nil, err
+	}
+	s.logRotationStatus(ctx)
+	return s, nil
+}
+
+// describeKey updates the KeyID from the key metadata, and returns the key state.
+func (s *awskmsRemoteService) describeKey(ctx context.Context) (types.KeyState, error) {
+	out, err := s.client.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(s.keyARN)})
+	if err != nil {
+		return "", fmt.Errorf("/!\\ unable to describe the KMS key %s: %v", s.keyARN, err)
+	}
+	metadata := out.KeyMetadata
+	if metadata.KeySpec != types.KeySpecSymmetricDefault || metadata.KeyUsage != types.KeyUsageTypeEncryptDecrypt {
+		return "", fmt.Errorf("/!\\ KMS key %s is a %s key for %s, expecting a %s key for %s", s.keyARN,
+			metadata.KeySpec, metadata.KeyUsage, types.KeySpecSymmetricDefault, types.KeyUsageTypeEncryptDecrypt)
+	}
+
+	keyID := aws.ToString(metadata.Arn)
+	if material := aws.ToString(metadata.CurrentKeyMaterialId); len(material) != 0 {
+		keyID += awskmsMaterialSeparator + material
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if keyID != s.keyID {
+		if len(s.keyID) != 0 {
+			zap.L().Info("INFO: KMS key rotated", logger.Provider(awskmsProvider), zap.String("from", s.keyID), zap.String("to", keyID))
+		}
+		s.keyID = keyID
+	}
+	return metadata.KeyState, nil
+}
+
+// logRotationStatus logs the automatic rotation settings of the key, when readable.
+func (s *awskmsRemoteService) logRotationStatus(ctx context.Context) {
+	out, err := s.client.GetKeyRotationStatus(ctx, &kms.GetKeyRotationStatusInput{KeyId: aws.String(s.keyARN)})
+	if err != nil {
+		zap.L().Warn("unable to read the KMS key rotation status", logger.Provider(awskmsProvider), zap.Error(err))
+		return
+	}
+	fields := []zap.Field{logger.Provider(awskmsProvider), logger.KeyID(s.currentKeyID()), zap.Bool("rotation", out.KeyRotationEnabled)}
+	if out.NextRotationDate != nil {
+		fields = append(fields, zap.Time("nextrotation", *out.NextRotationDate))
+	}
+	zap.L().Info("INFO: KMS key described", fields...)
+}
+
+func (s *awskmsRemoteService) currentKeyID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keyID
+}
+
+func (s *awskmsRemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	keyID := s.currentKeyID()
+
+	ctx, span := tracing.Tracer().Start(ctx, "awskms.Encrypt")
+	defer span.End()
+	out, err := s.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(s.keyARN),
+		Plaintext:         plaintext,
+		EncryptionContext: s.encryptionContext,
+	})
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ KMS encrypt failed: %v", err)
+	}
+
+	return &service.EncryptResponse{
+		Ciphertext: out.CiphertextBlob,
+		KeyID:      keyID,
+	}, nil
+}
+
+// Decrypt decrypts with the key ARN of the KeyID, so that KMS refuses a ciphertext
+// of another key, and with the encryption context of the cluster.
+func (s *awskmsRemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	keyARN, _, _ := strings.Cut(req.KeyID, awskmsMaterialSeparator)
+	if !arn.IsARN(keyARN) {
+		return nil, fmt.Errorf("/!\\ invalid keyID")
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "awskms.Decrypt")
+	defer span.End()
+	out, err := s.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(keyARN),
+		CiphertextBlob:    req.Ciphertext,
+		EncryptionContext: s.encryptionContext,
+	})
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ KMS decrypt failed: %v", err)
+	}
+	return out.Plaintext, nil
+}
+
+// Status describes the key again, to follow its rotations, and reports it healthy
+// when it is enabled.
+func (s *awskmsRemoteService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	healthz := healthOK
+	state, err := s.describeKey(ctx)
+	switch {
+	case err != nil:
+		zap.L().Error("awskms: unable to describe the key", logger.Provider(awskmsProvider), logger.Op("status"), zap.Error(err))
+		healthz = healthNOK
+	case state != types.KeyStateEnabled:
+		healthz = fmt.Sprintf("key %s is %s", s.keyARN, state)
+	}
+
+	return &service.StatusResponse{
+		Version: "v2",
+		Healthz: healthz,
+		KeyID:   s.currentKeyID(),
+	}, nil
+}
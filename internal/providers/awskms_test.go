@@ -0,0 +1,174 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/kms/pkg/service"
+)
+
+const awskmsTestARN = "arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+// awskmsFake serves the KMS JSON protocol operations used by the awskms provider,
+// like a LocalStack or moto server.
+type awskmsFake struct {
+	mu       sync.Mutex
+	state    string
+	material string
+	// ciphertexts maps the issued ciphertexts to their key, context and plaintext.
+	ciphertexts map[string]awskmsFakeEntry
+}
+
+type awskmsFakeEntry struct {
+	context   map[string]string
+	plaintext []byte
+}
+
+func (f *awskmsFake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		KeyId             string
+		Plaintext         []byte
+		CiphertextBlob    []byte
+		EncryptionContext map[string]string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out any
+	fail := func(errType string) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type": %q, "message": "fake KMS error"}`, errType)
+	}
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "TrentService.") {
+	case "DescribeKey":
+		out = map[string]any{"KeyMetadata": map[string]any{
+			"KeyId":                "1234abcd-12ab-34cd-56ef-1234567890ab",
+			"Arn":                  awskmsTestARN,
+			"KeySpec":              "SYMMETRIC_DEFAULT",
+			"KeyUsage":             "ENCRYPT_DECRYPT",
+			"KeyState":             f.state,
+			"CurrentKeyMaterialId": f.material,
+		}}
+	case "GetKeyRotationStatus":
+		out = map[string]any{"KeyId": awskmsTestARN, "KeyRotationEnabled": true}
+	case "Encrypt":
+		if in.KeyId != awskmsTestARN || f.state != "Enabled" {
+			fail("DisabledException")
+			return
+		}
+		blob := make([]byte, 16)
+		rand.Read(blob)
+		f.ciphertexts[hex.EncodeToString(blob)] = awskmsFakeEntry{context: in.EncryptionContext, plaintext: in.Plaintext}
+		out = map[string]any{"KeyId": awskmsTestARN, "CiphertextBlob": blob}
+	case "Decrypt":
+		entry, ok := f.ciphertexts[hex.EncodeToString(in.CiphertextBlob)]
+		if !ok || in.KeyId != awskmsTestARN || !maps.Equal(entry.context, in.EncryptionContext) {
+			fail("InvalidCiphertextException")
+			return
+		}
+		out = map[string]any{"KeyId": awskmsTestARN, "Plaintext": entry.plaintext}
+	default:
+		fail("UnsupportedOperationException")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(out)
+}
+
+// awskmsTestConfig returns the configuration of a provider of clusterName using the
+// fake server at endpoint, with static credentials.
+func awskmsTestConfig(t *testing.T, endpoint, clusterName string) ConfigSource {
+	t.Helper()
+	dir := t.TempDir()
+	credentials := filepath.Join(dir, "credentials")
+	if err := os.WriteFile(credentials, []byte("[default]\naws_access_key_id = AKIDTEST\naws_secret_access_key = secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return ConfigSource{Inline: []byte(fmt.Sprintf(`{"keyarn": %q, "clustername": %q, "endpoint": %q, "credentialsfile": %q}`,
+		awskmsTestARN, clusterName, endpoint, credentials))}
+}
+
+func TestAWSKMSProvider(t *testing.T) {
+	ctx := context.Background()
+	fake := &awskmsFake{state: "Enabled", material: "material-1", ciphertexts: map[string]awskmsFakeEntry{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	svc, err := NewAWSKMSRemoteService(awskmsTestConfig(t, server.URL, "prod"))
+	if err != nil {
+		t.Fatalf("NewAWSKMSRemoteService() error: %v", err)
+	}
+	status, err := svc.Status(ctx)
+	if err != nil || status.Healthz != healthOK || status.KeyID != awskmsTestARN+"#material-1" {
+		t.Fatalf("Status() = %v, %v", status, err)
+	}
+	before, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+
+	// rotating the key material changes the KeyID.
+	fake.mu.Lock()
+	fake.material = "material-2"
+	fake.mu.Unlock()
+	status, err = svc.Status(ctx)
+	if err != nil || status.Healthz != healthOK || status.KeyID != awskmsTestARN+"#material-2" {
+		t.Fatalf("Status() after rotation = %v, %v", status, err)
+	}
+	after, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+	for _, resp := range []*service.EncryptResponse{before, after} {
+		plaintext, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: resp.Ciphertext, KeyID: resp.KeyID})
+		if err != nil || string(plaintext) != "secret" {
+			t.Fatalf("Decrypt(%s) = %q, %v", resp.KeyID, plaintext, err)
+		}
+	}
+
+	// the ciphertexts are bound to the cluster.
+	other, err := NewAWSKMSRemoteService(awskmsTestConfig(t, server.URL, "staging"))
+	if err != nil {
+		t.Fatalf("NewAWSKMSRemoteService() error: %v", err)
+	}
+	if _, err := other.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: after.Ciphertext, KeyID: after.KeyID}); err == nil {
+		t.Fatal("Decrypt() by another cluster succeeded")
+	}
+
+	fake.mu.Lock()
+	fake.state = "Disabled"
+	fake.mu.Unlock()
+	if status, _ := svc.Status(ctx); !strings.Contains(status.Healthz, "is Disabled") {
+		t.Fatalf("Status() with a disabled key = %v", status)
+	}
+}
+
+func TestValidateAWSKMSConfig(t *testing.T) {
+	testCases := []configTestCase{
+		{name: "Valid config", input: `{"keyarn": "` + awskmsTestARN + `", "clustername": "prod"}`},
+		{name: "Alias ARN", input: `{"keyarn": "arn:aws:kms:eu-west-1:111122223333:alias/kleidi", "clustername": "prod", "endpoint": "http://localhost:4566"}`},
+		{name: "Key id instead of ARN", input: `{"keyarn": "1234abcd-12ab-34cd-56ef-1234567890ab", "clustername": "prod"}`, expectErr: `must be a KMS key or alias ARN`},
+		{name: "Missing cluster name", input: `{"keyarn": "` + awskmsTestARN + `"}`, expectErr: `field "clustername" is required`},
+		{name: "Typo in field name", input: `{"keyArn": "` + awskmsTestARN + `", "clustername": "prod"}`, expectErr: `did you mean "keyarn"?`},
+	}
+
+	testValidateConfig(t, "awskms", testCases)
+}
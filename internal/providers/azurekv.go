@@ -0,0 +1,277 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"github.com/beezy-dev/kleidi/internal/tracing"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	azurekvProvider = "azurekv"
+	// azurekvAnnotationKey records the wrapping algorithm of a ciphertext.
+	azurekvAnnotationKey = "azurekv.kleidi.beezy.dev/algorithm"
+
+	azurekvTimeOut = 10 * time.Second
+)
+
+// The wrapping algorithms: RSA-OAEP-256 with the RSA keys of Key Vault or Managed HSM,
+// AES key wrap with the oct-HSM keys of Managed HSM.
+var azurekvAlgorithms = []string{
+	string(azkeys.EncryptionAlgorithmRSAOAEP256),
+	string(azkeys.EncryptionAlgorithmA128KW),
+	string(azkeys.EncryptionAlgorithmA192KW),
+	string(azkeys.EncryptionAlgorithmA256KW),
+}
+
+var azurekvAuthMethods = []string{"workload", "cert"}
+
+var _ service.Service = &azurekvRemoteService{}
+
+// azurekvConfig is the azurekv provider configuration.
+type azurekvConfig struct {
+	// VaultURL is the https URL of the Key Vault or the Managed HSM, or of a mock.
+	VaultURL string `json:"vaulturl"`
+	// KeyName is the name of the key, whose current version wraps.
+	KeyName string `json:"keyname"`
+	// Algorithm is RSA-OAEP-256 or A128KW, A192KW, A256KW, defaulting to RSA-OAEP-256.
+	Algorithm string `json:"algorithm"`
+	// AuthMethod is workload or cert, defaulting to workload.
+	AuthMethod string `json:"authmethod"`
+	// TenantID and ClientID identify the application. With workload identity, they
+	// default to the AZURE_TENANT_ID and AZURE_CLIENT_ID environment variables.
+	TenantID string `json:"tenantid"`
+	ClientID string `json:"clientid"`
+	// ClientCert is the PEM file holding the certificate and the private key of the
+	// application, with the cert auth method.
+	ClientCert string `json:"clientcert"`
+	// DisableChallengeResourceVerification accepts the authentication challenges of
+	// a resource outside of the vault domain, e.g. a local mock.
+	DisableChallengeResourceVerification bool `json:"disablechallengeresourceverification"`
+}
+
+func (c *azurekvConfig) validate() error {
+	if len(c.VaultURL) == 0 {
+		return errors.New("field \"vaulturl\" is required")
+	}
+	if u, err := url.Parse(c.VaultURL); err != nil || u.Scheme != "https" || len(u.Host) == 0 {
+		return fmt.Errorf("field \"vaulturl\" must be an https URL, got %q", c.VaultURL)
+	}
+	if len(c.KeyName) == 0 {
+		return errors.New("field \"keyname\" is required")
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = string(azkeys.EncryptionAlgorithmRSAOAEP256)
+	}
+	if !slices.Contains(azurekvAlgorithms, c.Algorithm) {
+		return fmt.Errorf("field \"algorithm\" set to %q is not supported. Only %v are valid options", c.Algorithm, azurekvAlgorithms)
+	}
+	if c.AuthMethod == "" {
+		c.AuthMethod = "workload"
+	}
+	if !slices.Contains(azurekvAuthMethods, c.AuthMethod) {
+		return fmt.Errorf("field \"authmethod\" set to %q is not supported. Only %v are valid options", c.AuthMethod, azurekvAuthMethods)
+	}
+	if c.AuthMethod == "cert" {
+		if len(c.TenantID) == 0 || len(c.ClientID) == 0 || len(c.ClientCert) == 0 {
+			return errors.New("fields \"tenantid\", \"clientid\" and \"clientcert\" are required with the cert auth method")
+		}
+		if err := fileExists(c.ClientCert); err != nil {
+			return fmt.Errorf("field \"clientcert\": %v", err)
+		}
+	}
+	return nil
+}
+
+func readAzureKVConfig(source ConfigSource) (*azurekvConfig, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read azurekv config: %v", err)
+	}
+	config := &azurekvConfig{}
+	if err := decodeStrict(data, config, true); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid azurekv config %s: %v", source, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid azurekv config %s: %v", source, err)
+	}
+	return config, nil
+}
+
+// credential returns the token credential of the configured auth method.
+func (c *azurekvConfig) credential() (azcore.TokenCredential, error) {
+	if c.AuthMethod == "cert" {
+		data, err := os.ReadFile(c.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("/!\\ unable to read the client certificate: %v", err)
+		}
+		certs, key, err := azidentity.ParseCertificates(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("/!\\ invalid client certificate %s: %v", c.ClientCert, err)
+		}
+		return azidentity.NewClientCertificateCredential(c.TenantID, c.ClientID, certs, key, nil)
+	}
+	return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+		TenantID: c.TenantID,
+		ClientID: c.ClientID,
+	})
+}
+
+// azurekvRemoteService wraps the data keys with the wrapKey and unwrapKey operations
+// of Azure Key Vault or Managed HSM. The KeyID is the key identifier, holding the key
+// version, so that a rotation of the key changes the KeyID reported to the API server.
+type azurekvRemoteService struct {
+	client    *azkeys.Client
+	keyName   string
+	algorithm azkeys.EncryptionAlgorithm
+
+	mu sync.RWMutex
+	// kid is the identifier of the current version of the key.
+	kid azkeys.ID
+}
+
+// NewAzureKVRemoteService creates an azurekv remote service, and reads the current
+// version of the key.
+func NewAzureKVRemoteService(source ConfigSource) (service.Service, error) {
+	config, err := readAzureKVConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := config.credential()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ unable to create the %s credential: %v", config.AuthMethod, err)
+	}
+	return newAzureKVRemoteService(config, credential, azcore.ClientOptions{})
+}
+
+func newAzureKVRemoteService(config *azurekvConfig, credential azcore.TokenCredential, clientOptions azcore.ClientOptions) (*azurekvRemoteService, error) {
+	client, err := azkeys.NewClient(config.VaultURL, credential, &azkeys.ClientOptions{
+		ClientOptions:                        clientOptions,
+		DisableChallengeResourceVerification: config.DisableChallengeResourceVerification,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to initialize Key Vault client: %v", err)
+	}
+
+	s := &azurekvRemoteService{
+		client:    client,
+		keyName:   config.KeyName,
+		algorithm: azkeys.EncryptionAlgorithm(config.Algorithm),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), azurekvTimeOut)
+	defer cancel()
+	if _, err := s.currentKey(ctx); err != nil {
+		return nil, err
+	}
+	zap.L().Info("INFO: Key Vault key found", logger.Provider(azurekvProvider), logger.KeyID(string(s.currentKID())), zap.String("algorithm", config.Algorithm))
+	return s, nil
+}
+
+// currentKey updates the KeyID to the current version of the key, and returns its attributes.
+func (s *azurekvRemoteService) currentKey(ctx context.Context) (*azkeys.KeyAttributes, error) {
+	resp, err := s.client.GetKey(ctx, s.keyName, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ unable to read the key %s: %v", s.keyName, err)
+	}
+	if resp.Key == nil || resp.Key.KID == nil {
+		return nil, fmt.Errorf("/!\\ key %s returned without identifier", s.keyName)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kid := *resp.Key.KID; kid != s.kid {
+		if len(s.kid) != 0 {
+			zap.L().Info("INFO: Key Vault key rotated", logger.Provider(azurekvProvider), zap.String("from", string(s.kid)), zap.String("to", string(kid)))
+		}
+		s.kid = kid
+	}
+	return resp.Attributes, nil
+}
+
+func (s *azurekvRemoteService) currentKID() azkeys.ID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.kid
+}
+
+func (s *azurekvRemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	kid := s.currentKID()
+
+	ctx, span := tracing.Tracer().Start(ctx, "azurekv.WrapKey")
+	defer span.End()
+	resp, err := s.client.WrapKey(ctx, kid.Name(), kid.Version(), azkeys.KeyOperationParameters{
+		Algorithm: &s.algorithm,
+		Value:     plaintext,
+	}, nil)
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ Key Vault wrapKey failed: %v", err)
+	}
+
+	return &service.EncryptResponse{
+		Ciphertext: resp.Result,
+		KeyID:      string(kid),
+		// the algorithm is kept, so that a change of configuration does not break the unwrapping.
+		Annotations: map[string][]byte{azurekvAnnotationKey: []byte(s.algorithm)},
+	}, nil
+}
+
+// Decrypt unwraps with the key version of the KeyID, which keeps working after the
+// rotation of the key as long as the former version is enabled.
+func (s *azurekvRemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	kid := azkeys.ID(req.KeyID)
+	if kid.Name() != s.keyName || len(kid.Version()) == 0 {
+		return nil, fmt.Errorf("/!\\ invalid keyID")
+	}
+	// only the supported algorithms reach Key Vault, whatever the request carries.
+	algorithm := azkeys.EncryptionAlgorithm(req.Annotations[azurekvAnnotationKey])
+	if !slices.Contains(azurekvAlgorithms, string(algorithm)) {
+		return nil, fmt.Errorf("/!\\ algorithm %q in annotations is not supported. Only %v are valid options", algorithm, azurekvAlgorithms)
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "azurekv.UnwrapKey")
+	defer span.End()
+	resp, err := s.client.UnwrapKey(ctx, kid.Name(), kid.Version(), azkeys.KeyOperationParameters{
+		Algorithm: &algorithm,
+		Value:     req.Ciphertext,
+	}, nil)
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ Key Vault unwrapKey failed: %v", err)
+	}
+	return resp.Result, nil
+}
+
+// Status reads the current version of the key again, to follow its rotations, and
+// reports it healthy when it is enabled and not expired.
+func (s *azurekvRemoteService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	healthz := healthOK
+	attributes, err := s.currentKey(ctx)
+	switch {
+	case err != nil:
+		zap.L().Error("azurekv: unable to read the key", logger.Provider(azurekvProvider), logger.Op("status"), zap.Error(err))
+		healthz = healthNOK
+	case attributes != nil && attributes.Enabled != nil && !*attributes.Enabled:
+		healthz = fmt.Sprintf("key %s is disabled", s.keyName)
+	case attributes != nil && attributes.Expires != nil && attributes.Expires.Before(time.Now()):
+		healthz = fmt.Sprintf("key %s expired on %s", s.keyName, attributes.Expires.Format(time.RFC3339))
+	}
+
+	return &service.StatusResponse{
+		Version: "v2",
+		Healthz: healthz,
+		KeyID:   string(s.currentKID()),
+	}, nil
+}
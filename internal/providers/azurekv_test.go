@@ -0,0 +1,205 @@
+package providers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"k8s.io/kms/pkg/service"
+)
+
+const azurekvTestToken = "test-token"
+
+type azurekvTestCredential struct{}
+
+func (azurekvTestCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: azurekvTestToken}, nil
+}
+
+// azurekvMock is a Key Vault mock serving the operations used by the azurekv provider,
+// with the bearer challenge of Key Vault. The versions of the key wrap with AES-GCM,
+// whatever the requested algorithm.
+type azurekvMock struct {
+	url string
+
+	mu       sync.Mutex
+	versions []string
+	aeads    map[string]cipher.AEAD
+	enabled  bool
+}
+
+func (m *azurekvMock) addVersion(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	version := fmt.Sprintf("v%d", len(m.versions)+1)
+	m.versions = append(m.versions, version)
+	m.aeads[version] = aead
+	return version
+}
+
+func (m *azurekvMock) kid(version string) string {
+	return m.url + "/keys/kleidi/" + version
+}
+
+func (m *azurekvMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+azurekvTestToken {
+		w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant-id", resource="https://vault.azure.net"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "keys" || parts[1] != "kleidi" {
+		http.Error(w, `{"error": {"code": "KeyNotFound"}}`, http.StatusNotFound)
+		return
+	}
+
+	var out map[string]any
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 2:
+		out = map[string]any{
+			"key":        map[string]any{"kid": m.kid(m.versions[len(m.versions)-1]), "kty": "RSA"},
+			"attributes": map[string]any{"enabled": m.enabled},
+		}
+	case r.Method == http.MethodPost && len(parts) == 4:
+		var in struct {
+			Alg   string `json:"alg"`
+			Value string `json:"value"`
+		}
+		json.NewDecoder(r.Body).Decode(&in)
+		value, err := base64.RawURLEncoding.DecodeString(in.Value)
+		aead, ok := m.aeads[parts[2]]
+		if err != nil || !ok || in.Alg != "RSA-OAEP-256" {
+			http.Error(w, `{"error": {"code": "BadParameter"}}`, http.StatusBadRequest)
+			return
+		}
+		var result []byte
+		if parts[3] == "wrapkey" {
+			nonce := make([]byte, aead.NonceSize())
+			rand.Read(nonce)
+			result = aead.Seal(nonce, nonce, value, nil)
+		} else if len(value) > aead.NonceSize() {
+			result, err = aead.Open(nil, value[:aead.NonceSize()], value[aead.NonceSize():], nil)
+		}
+		if err != nil || result == nil {
+			http.Error(w, `{"error": {"code": "BadParameter"}}`, http.StatusBadRequest)
+			return
+		}
+		out = map[string]any{"kid": m.kid(parts[2]), "value": base64.RawURLEncoding.EncodeToString(result)}
+	default:
+		http.Error(w, `{"error": {"code": "BadParameter"}}`, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func TestAzureKVProvider(t *testing.T) {
+	ctx := context.Background()
+	mock := &azurekvMock{aeads: map[string]cipher.AEAD{}, enabled: true}
+	server := httptest.NewTLSServer(mock)
+	defer server.Close()
+	mock.url = server.URL
+	first := mock.addVersion(t)
+
+	config := &azurekvConfig{VaultURL: server.URL, KeyName: "kleidi", DisableChallengeResourceVerification: true}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := newAzureKVRemoteService(config, azurekvTestCredential{}, azcore.ClientOptions{Transport: server.Client()})
+	if err != nil {
+		t.Fatalf("newAzureKVRemoteService() error: %v", err)
+	}
+
+	status, err := svc.Status(ctx)
+	if err != nil || status.Healthz != healthOK || status.KeyID != mock.kid(first) {
+		t.Fatalf("Status() = %v, %v", status, err)
+	}
+	before, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+
+	// a new version of the key changes the KeyID.
+	second := mock.addVersion(t)
+	status, err = svc.Status(ctx)
+	if err != nil || status.Healthz != healthOK || status.KeyID != mock.kid(second) {
+		t.Fatalf("Status() after rotation = %v, %v", status, err)
+	}
+	after, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+	for _, resp := range []*service.EncryptResponse{before, after} {
+		plaintext, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{
+			Ciphertext:  resp.Ciphertext,
+			KeyID:       resp.KeyID,
+			Annotations: resp.Annotations,
+		})
+		if err != nil || string(plaintext) != "secret" {
+			t.Fatalf("Decrypt(%s) = %q, %v", resp.KeyID, plaintext, err)
+		}
+	}
+	if _, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{
+		Ciphertext:  before.Ciphertext,
+		KeyID:       server.URL + "/keys/other/v1",
+		Annotations: before.Annotations,
+	}); err == nil {
+		t.Fatal("Decrypt() with the KeyID of another key succeeded")
+	}
+	if algorithm := string(before.Annotations[azurekvAnnotationKey]); algorithm != "RSA-OAEP-256" {
+		t.Fatalf("algorithm annotation = %q, want RSA-OAEP-256", algorithm)
+	}
+	for _, annotations := range []map[string][]byte{
+		{azurekvAnnotationKey: []byte("RSA1_5")},
+		{annotationKey: []byte("RSA-OAEP-256")},
+	} {
+		if _, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{
+			Ciphertext:  before.Ciphertext,
+			KeyID:       before.KeyID,
+			Annotations: annotations,
+		}); err == nil || !strings.Contains(err.Error(), "is not supported") {
+			t.Fatalf("Decrypt() with the annotations %q = %v, want an unsupported algorithm", annotations, err)
+		}
+	}
+
+	mock.mu.Lock()
+	mock.enabled = false
+	mock.mu.Unlock()
+	if status, _ := svc.Status(ctx); !strings.Contains(status.Healthz, "is disabled") {
+		t.Fatalf("Status() with a disabled key = %v", status)
+	}
+}
+
+func TestValidateAzureKVConfig(t *testing.T) {
+	cert := writeConfig(t, "")
+	testCases := []configTestCase{
+		{name: "Workload identity", input: `{"vaulturl": "https://kleidi.vault.azure.net", "keyname": "kleidi"}`},
+		{name: "Managed HSM with AES key wrap", input: `{"vaulturl": "https://kleidi.managedhsm.azure.net", "keyname": "kleidi", "algorithm": "A256KW"}`},
+		{name: "Client certificate", input: `{"vaulturl": "https://kleidi.vault.azure.net", "keyname": "kleidi", "authmethod": "cert", "tenantid": "t", "clientid": "c", "clientcert": "` + cert + `"}`},
+		{name: "Missing client certificate", input: `{"vaulturl": "https://kleidi.vault.azure.net", "keyname": "kleidi", "authmethod": "cert"}`, expectErr: `are required with the cert auth method`},
+		{name: "HTTP vault URL", input: `{"vaulturl": "http://kleidi.vault.azure.net", "keyname": "kleidi"}`, expectErr: `must be an https URL`},
+		{name: "Unsupported algorithm", input: `{"vaulturl": "https://kleidi.vault.azure.net", "keyname": "kleidi", "algorithm": "RSA1_5"}`, expectErr: `field "algorithm" set to "RSA1_5"`},
+	}
+
+	testValidateConfig(t, "azurekv", testCases)
+}
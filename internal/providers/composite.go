@@ -0,0 +1,298 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	compositeProvider = "composite"
+	// compositeAnnotationKey tags the ciphertexts with the name of the member which
+	// encrypted them.
+	compositeAnnotationKey = "composite.kleidi.beezy.dev"
+	// compositeKeyIDSeparator separates the member name from the member KeyID.
+	compositeKeyIDSeparator = "/"
+)
+
+var (
+	memberNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+	// memberProviders are the providers which can be members, built from their config.
+	memberProviders = []string{"hvault", "openbao", "softhsm", "localkey", "kmip", "awskms", "azurekv", "gcpkms"}
+)
+
+var _ service.Service = &compositeRemoteService{}
+
+// memberConfig is a provider wrapped by the composite or the layered provider.
+type memberConfig struct {
+	// Name identifies the member in the ciphertext tags and the KeyIDs.
+	Name string `json:"name"`
+	// Provider is the provider of the member.
+	Provider string `json:"provider"`
+	// ConfigFile is the path of the member provider configuration file.
+	ConfigFile string `json:"configfile"`
+	// Config is the member provider configuration, instead of ConfigFile.
+	Config json.RawMessage `json:"config"`
+}
+
+func (m *memberConfig) source() ConfigSource {
+	return ConfigSource{File: m.ConfigFile, Inline: m.Config}
+}
+
+func (m *memberConfig) validate() error {
+	if !memberNameRegexp.MatchString(m.Name) {
+		return fmt.Errorf("field \"name\" must be lowercase alphanumeric characters or '-', got %q", m.Name)
+	}
+	if !slices.Contains(memberProviders, m.Provider) {
+		return fmt.Errorf("member %s: field \"provider\" set to %q is not supported. Only %v are valid options", m.Name, m.Provider, memberProviders)
+	}
+	if (len(m.ConfigFile) == 0) == (len(m.Config) == 0) {
+		return fmt.Errorf("member %s: exactly one of the fields \"configfile\" or \"config\" is required", m.Name)
+	}
+	if len(m.ConfigFile) != 0 {
+		if err := fileExists(m.ConfigFile); err != nil {
+			return fmt.Errorf("member %s: field \"configfile\": %v", m.Name, err)
+		}
+	}
+	if err := ValidateConfig(m.Provider, m.source()); err != nil {
+		return fmt.Errorf("member %s: %v", m.Name, err)
+	}
+	return nil
+}
+
+// checkMembersKeys checks the exact field names of the members listed by field in a
+// decoded config.
+func checkMembersKeys(data []byte, field string) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	var members []json.RawMessage
+	if err := json.Unmarshal(raw[field], &members); err != nil && len(raw[field]) != 0 {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	for i, member := range members {
+		if err := checkKeys(member, &memberConfig{}); err != nil {
+			return fmt.Errorf("member %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validateMembers validates members, whose names must be unique.
+func validateMembers(members []memberConfig) error {
+	names := map[string]bool{}
+	for i := range members {
+		if err := members[i].validate(); err != nil {
+			return err
+		}
+		if names[members[i].Name] {
+			return fmt.Errorf("member name %q is not unique", members[i].Name)
+		}
+		names[members[i].Name] = true
+	}
+	return nil
+}
+
+// compositeConfig is the composite provider configuration.
+type compositeConfig struct {
+	// Primary is the name of the member which encrypts.
+	Primary string `json:"primary"`
+	// Untagged is the name of the member which decrypts the ciphertexts without a
+	// member tag, encrypted before the introduction of the composite provider.
+	Untagged string `json:"untagged"`
+	// Members are the wrapped providers.
+	Members []memberConfig `json:"members"`
+}
+
+func (c *compositeConfig) validate() error {
+	if len(c.Members) == 0 {
+		return errors.New("field \"members\" is required")
+	}
+	if err := validateMembers(c.Members); err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(c.Members, func(m memberConfig) bool { return m.Name == c.Primary }) {
+		return fmt.Errorf("field \"primary\" set to %q is not a member name", c.Primary)
+	}
+	if len(c.Untagged) != 0 && !slices.ContainsFunc(c.Members, func(m memberConfig) bool { return m.Name == c.Untagged }) {
+		return fmt.Errorf("field \"untagged\" set to %q is not a member name", c.Untagged)
+	}
+	return nil
+}
+
+func readCompositeConfig(source ConfigSource) (*compositeConfig, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read composite config: %v", err)
+	}
+	config := &compositeConfig{}
+	if err := decodeStrict(data, config, true); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid composite config %s: %v", source, err)
+	}
+	if err := checkMembersKeys(data, "members"); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid composite config %s: %v", source, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid composite config %s: %v", source, err)
+	}
+	return config, nil
+}
+
+// member is a built member of the composite or the layered provider.
+type member struct {
+	name    string
+	service service.Service
+}
+
+// newMembers builds the services of members, closing them on failure.
+func newMembers(members []memberConfig) ([]member, error) {
+	built := make([]member, 0, len(members))
+	for i := range members {
+		svc, err := NewRemoteService(members[i].Provider, members[i].source())
+		if err != nil {
+			closeMembers(built)
+			return nil, fmt.Errorf("/!\\ member %s: %v", members[i].Name, err)
+		}
+		built = append(built, member{name: members[i].Name, service: svc})
+	}
+	return built, nil
+}
+
+func closeMembers(members []member) error {
+	var errs []error
+	for _, m := range members {
+		if err := Close(m.service); err != nil {
+			errs = append(errs, fmt.Errorf("member %s: %v", m.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// membersStatus returns the Status of every member, and the first unhealthy one
+// described, or healthOK.
+func membersStatus(ctx context.Context, members []member) ([]*service.StatusResponse, string) {
+	statuses := make([]*service.StatusResponse, len(members))
+	healthz := healthOK
+	for i, m := range members {
+		status, err := m.service.Status(ctx)
+		if err != nil {
+			zap.L().Error("member status failed", logger.Op("status"), zap.String("member", m.name), zap.Error(err))
+			status = &service.StatusResponse{Healthz: healthNOK}
+		}
+		statuses[i] = status
+		if status.Healthz != healthOK && healthz == healthOK {
+			healthz = fmt.Sprintf("member %s: %s", m.name, status.Healthz)
+		}
+	}
+	return statuses, healthz
+}
+
+// compositeRemoteService encrypts with its primary member, and decrypts with the
+// member named by the tag of the ciphertext, so that a migration between backends
+// is a change of the primary member.
+type compositeRemoteService struct {
+	members  []member
+	primary  int
+	untagged int
+}
+
+// NewCompositeRemoteService creates a composite remote service, building each member.
+func NewCompositeRemoteService(source ConfigSource) (service.Service, error) {
+	config, err := readCompositeConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	members, err := newMembers(config.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &compositeRemoteService{members: members, untagged: -1}
+	for i, m := range members {
+		if m.name == config.Primary {
+			s.primary = i
+		}
+		if m.name == config.Untagged {
+			s.untagged = i
+		}
+	}
+	zap.L().Info("INFO: composite provider ready", logger.Provider(compositeProvider),
+		zap.String("primary", config.Primary), zap.String("untagged", config.Untagged), zap.Int("members", len(members)))
+	return s, nil
+}
+
+// Close closes every member.
+func (s *compositeRemoteService) Close() error {
+	return closeMembers(s.members)
+}
+
+// Encrypt encrypts with the primary member, prefixing the KeyID with its name and
+// tagging the ciphertext with it.
+func (s *compositeRemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	primary := s.members[s.primary]
+	resp, err := primary.service.Encrypt(ctx, uid, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := maps.Clone(resp.Annotations)
+	if annotations == nil {
+		annotations = map[string][]byte{}
+	}
+	annotations[compositeAnnotationKey] = []byte(primary.name)
+	return &service.EncryptResponse{
+		Ciphertext:  resp.Ciphertext,
+		KeyID:       primary.name + compositeKeyIDSeparator + resp.KeyID,
+		Annotations: annotations,
+	}, nil
+}
+
+// Decrypt dispatches to the member of the ciphertext tag, or to the untagged member.
+func (s *compositeRemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	name, tagged := req.Annotations[compositeAnnotationKey]
+	if !tagged {
+		if s.untagged < 0 {
+			return nil, fmt.Errorf("/!\\ ciphertext without %s annotation", compositeAnnotationKey)
+		}
+		return s.members[s.untagged].service.Decrypt(ctx, uid, req)
+	}
+
+	i := slices.IndexFunc(s.members, func(m member) bool { return m.name == string(name) })
+	if i < 0 {
+		return nil, fmt.Errorf("/!\\ ciphertext of the unknown member %q", name)
+	}
+	memberKeyID, ok := strings.CutPrefix(req.KeyID, string(name)+compositeKeyIDSeparator)
+	if !ok {
+		return nil, fmt.Errorf("/!\\ invalid keyID")
+	}
+
+	annotations := maps.Clone(req.Annotations)
+	delete(annotations, compositeAnnotationKey)
+	return s.members[i].service.Decrypt(ctx, uid, &service.DecryptRequest{
+		Ciphertext:  req.Ciphertext,
+		KeyID:       memberKeyID,
+		Annotations: annotations,
+	})
+}
+
+// Status reports the KeyID of the primary member, and is healthy when every member
+// is, as the ciphertexts of any member must remain readable.
+func (s *compositeRemoteService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	statuses, healthz := membersStatus(ctx, s.members)
+	return &service.StatusResponse{
+		Version: "v2",
+		Healthz: healthz,
+		KeyID:   s.members[s.primary].name + compositeKeyIDSeparator + statuses[s.primary].KeyID,
+	}, nil
+}
@@ -0,0 +1,105 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/kms/pkg/service"
+)
+
+// localkeyMember returns the config of a localkey member generating its key in a
+// directory of dir.
+func localkeyMember(dir, name string) string {
+	return fmt.Sprintf(`{"name": %q, "provider": "localkey", "config": {"keydir": %q, "generate": true}}`,
+		name, filepath.Join(dir, name))
+}
+
+func TestCompositeProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// the ciphertexts of the former standalone provider.
+	former, err := NewLocalkeyRemoteService(ConfigSource{Inline: []byte(`{"keydir": "` + filepath.Join(dir, "hsm") + `", "generate": true}`)})
+	if err != nil {
+		t.Fatalf("NewLocalkeyRemoteService() error: %v", err)
+	}
+	untagged, err := former.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+
+	newComposite := func(primary string) service.Service {
+		t.Helper()
+		svc, err := NewCompositeRemoteService(ConfigSource{Inline: []byte(fmt.Sprintf(`{"primary": %q, "untagged": "hsm", "members": [%s, %s]}`,
+			primary, localkeyMember(dir, "hsm"), localkeyMember(dir, "vault")))})
+		if err != nil {
+			t.Fatalf("NewCompositeRemoteService() error: %v", err)
+		}
+		t.Cleanup(func() { Close(svc) })
+		return svc
+	}
+
+	before := newComposite("hsm")
+	hsm, err := before.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+	if string(hsm.Annotations[compositeAnnotationKey]) != "hsm" || !strings.HasPrefix(hsm.KeyID, "hsm/") {
+		t.Fatalf("Encrypt() = %v, want a ciphertext tagged with hsm", hsm)
+	}
+
+	// migrating is changing the primary member.
+	after := newComposite("vault")
+	status, err := after.Status(ctx)
+	if err != nil || status.Healthz != healthOK || !strings.HasPrefix(status.KeyID, "vault/") {
+		t.Fatalf("Status() = %v, %v", status, err)
+	}
+	vault, err := after.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+	for _, resp := range []*service.EncryptResponse{untagged, hsm, vault} {
+		plaintext, err := after.Decrypt(ctx, "uid", &service.DecryptRequest{
+			Ciphertext:  resp.Ciphertext,
+			KeyID:       resp.KeyID,
+			Annotations: resp.Annotations,
+		})
+		if err != nil || string(plaintext) != "secret" {
+			t.Fatalf("Decrypt(%s) = %q, %v", resp.KeyID, plaintext, err)
+		}
+	}
+
+	// a ciphertext tagged with another member is refused by the member.
+	annotations := map[string][]byte{annotationKey: []byte(aeadLayoutVersion), compositeAnnotationKey: []byte("vault")}
+	if _, err := after.Decrypt(ctx, "uid", &service.DecryptRequest{
+		Ciphertext:  hsm.Ciphertext,
+		KeyID:       "vault/" + strings.TrimPrefix(hsm.KeyID, "hsm/"),
+		Annotations: annotations,
+	}); err == nil {
+		t.Fatal("Decrypt() by another member succeeded")
+	}
+	annotations[compositeAnnotationKey] = []byte("other")
+	if _, err := after.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: hsm.Ciphertext, KeyID: hsm.KeyID, Annotations: annotations}); err == nil {
+		t.Fatal("Decrypt() of an unknown member succeeded")
+	}
+}
+
+func TestValidateCompositeConfig(t *testing.T) {
+	dir := t.TempDir()
+	hsm, vault := localkeyMember(dir, "hsm"), localkeyMember(dir, "vault")
+	testCases := []configTestCase{
+		{name: "Valid config", input: `{"primary": "vault", "untagged": "hsm", "members": [` + hsm + `, ` + vault + `]}`},
+		{name: "Member config file", input: `{"primary": "hsm", "members": [{"name": "hsm", "provider": "localkey", "configfile": "` + writeConfig(t, `{"keydir": "/opt/kleidi/keys"}`) + `"}]}`},
+		{name: "Unknown primary", input: `{"primary": "kmip", "members": [` + hsm + `]}`, expectErr: `field "primary" set to "kmip" is not a member name`},
+		{name: "Duplicate member", input: `{"primary": "hsm", "members": [` + hsm + `, ` + hsm + `]}`, expectErr: `member name "hsm" is not unique`},
+		{name: "Nested composite", input: `{"primary": "hsm", "members": [{"name": "hsm", "provider": "composite", "config": {}}]}`, expectErr: `field "provider" set to "composite" is not supported`},
+		{name: "Both config and file", input: `{"primary": "hsm", "members": [{"name": "hsm", "provider": "localkey", "config": {"keydir": "/tmp"}, "configfile": "/tmp/x"}]}`, expectErr: `exactly one of the fields`},
+		{name: "Invalid member config", input: `{"primary": "hsm", "members": [{"name": "hsm", "provider": "localkey", "config": {"keyDir": "/tmp"}}]}`, expectErr: `member hsm: /!\ invalid localkey config`},
+		{name: "Typo in member field name", input: `{"primary": "hsm", "members": [{"name": "hsm", "Provider": "localkey", "config": {"keydir": "/tmp"}}]}`, expectErr: `member 0: unknown field "Provider", did you mean "provider"?`},
+	}
+
+	testValidateConfig(t, "composite", testCases)
+}
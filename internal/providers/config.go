@@ -0,0 +1,213 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/kms/pkg/service"
+)
+
+const dryRunTimeOut = 30 * time.Second
+
+// ConfigSource locates the configuration document of a provider.
+type ConfigSource struct {
+	// File is the path of the provider configuration file.
+	File string
+	// Inline is the provider configuration embedded in the kleidi configuration.
+	// It takes precedence over File when set.
+	Inline []byte
+}
+
+// Read returns the provider configuration document.
+func (c ConfigSource) Read() ([]byte, error) {
+	if len(c.Inline) != 0 {
+		return c.Inline, nil
+	}
+	return os.ReadFile(c.File)
+}
+
+// String describes the source in the error messages.
+func (c ConfigSource) String() string {
+	if len(c.Inline) != 0 {
+		return "inline provider config"
+	}
+	return c.File
+}
+
+// ValidateConfig strictly decodes the config of provider and checks it
+// against the provider schema, without connecting to the backend.
+func ValidateConfig(provider string, source ConfigSource) error {
+	var err error
+	switch provider {
+	case "hvault", "openbao":
+		_, err = readConfig(provider, source)
+	case "softhsm":
+		_, err = readPKCS11Config(source)
+	case "localkey":
+		_, err = readLocalkeyConfig(source)
+	case "kmip":
+		_, err = readKMIPConfig(source)
+	case "awskms":
+		_, err = readAWSKMSConfig(source)
+	case "azurekv":
+		_, err = readAzureKVConfig(source)
+	case "gcpkms":
+		_, err = readGCPKMSConfig(source)
+	case "composite":
+		_, err = readCompositeConfig(source)
+	case "layered":
+		_, err = readLayeredConfig(source)
+	case "threshold":
+		_, err = readThresholdConfig(source)
+	case "kekcache":
+		_, err = readKEKCacheConfig(source)
+	case "tpm":
+		// the tpm provider has no configuration yet.
+	default:
+		err = fmt.Errorf("/!\\ provider %q has no configuration schema", provider)
+	}
+	return err
+}
+
+// NewRemoteService builds the remote service of provider from its config.
+func NewRemoteService(provider string, source ConfigSource) (service.Service, error) {
+	switch provider {
+	case "hvault":
+		return NewVaultClientRemoteService(source)
+	case "openbao":
+		return NewOpenBaoRemoteService(source)
+	case "softhsm":
+		return NewPKCS11RemoteService(source, keyID)
+	case "localkey":
+		return NewLocalkeyRemoteService(source)
+	case "kmip":
+		return NewKMIPRemoteService(source)
+	case "awskms":
+		return NewAWSKMSRemoteService(source)
+	case "azurekv":
+		return NewAzureKVRemoteService(source)
+	case "gcpkms":
+		return NewGCPKMSRemoteService(source)
+	case "composite":
+		return NewCompositeRemoteService(source)
+	case "layered":
+		return NewLayeredRemoteService(source)
+	case "threshold":
+		return NewThresholdRemoteService(source)
+	case "kekcache":
+		return NewKEKCacheRemoteService(source)
+	default:
+		return nil, fmt.Errorf("/!\\ provider %q can not be built from a config", provider)
+	}
+}
+
+// Close releases the backend resources of remoteService, when it holds any.
+func Close(remoteService service.Service) error {
+	if c, ok := remoteService.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// DryRun builds the provider from its config, which connects and authenticates
+// to the backend and looks up the key, then checks the provider Status.
+// No socket is opened.
+func DryRun(provider string, source ConfigSource) error {
+	if err := ValidateConfig(provider, source); err != nil {
+		return err
+	}
+
+	remoteService, err := NewRemoteService(provider, source)
+	if err != nil {
+		return err
+	}
+	defer Close(remoteService)
+
+	ctx, cancel := context.WithTimeout(context.Background(), dryRunTimeOut)
+	defer cancel()
+	return CheckStatus(ctx, provider, remoteService)
+}
+
+// CheckStatus returns an error unless the Status of remoteService is healthy.
+func CheckStatus(ctx context.Context, provider string, remoteService service.Service) error {
+	status, err := remoteService.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Healthz != healthOK {
+		return fmt.Errorf("/!\\ provider %s is unhealthy: %s", provider, status.Healthz)
+	}
+	return nil
+}
+
+// decodeStrict decodes data into v, rejecting the unknown fields. When exactKeys is set,
+// the keys must also match the json tags of v exactly, instead of the case-insensitive
+// matching of encoding/json, so that typos like "transitKey" are reported.
+func decodeStrict(data []byte, v any, exactKeys bool) error {
+	if exactKeys {
+		if err := checkKeys(data, v); err != nil {
+			return err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return nil
+}
+
+func checkKeys(data []byte, v any) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	known := map[string]bool{}
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); len(name) != 0 && name != "-" {
+			known[name] = true
+		}
+	}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if known[key] {
+			continue
+		}
+		for name := range known {
+			if strings.EqualFold(name, key) {
+				return fmt.Errorf("unknown field %q, did you mean %q?", key, name)
+			}
+		}
+		return fmt.Errorf("unknown field %q", key)
+	}
+	return nil
+}
+
+// fileExists reports an error when path does not exist or is a directory.
+func fileExists(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package providers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// configTestCase is a provider config validation case, valid when expectErr is empty.
+type configTestCase struct {
+	name      string
+	input     string
+	expectErr string
+}
+
+// testValidateConfig validates the input of each test case, written to a file, as a
+// config of provider.
+func testValidateConfig(t *testing.T, provider string, testCases []configTestCase) {
+	t.Helper()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateConfig(provider, ConfigSource{File: writeConfig(t, tc.input)})
+			if len(tc.expectErr) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, but got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+				t.Errorf("expected an error containing %q, but got: %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+// Test cases for the hvault config validation.
+func TestValidateHvaultConfig(t *testing.T) {
+	testCases := []configTestCase{
+		{
+			name:  "Valid k8s config",
+			input: `{"transitkey": "kleidi", "vaultrole": "kleidi", "address": "http://127.0.0.1:8200", "authmethod": "k8s", "authpath": "kubernetes"}`,
+		},
+		{
+			name:  "Valid cert config with default paths",
+			input: `{"transitkey": "kleidi", "address": "https://vault:8200", "authmethod": "cert"}`,
+		},
+		{
+			name:      "Typo in field name",
+			input:     `{"transitKey": "kleidi", "vaultrole": "kleidi", "address": "http://127.0.0.1:8200", "authmethod": "k8s"}`,
+			expectErr: `unknown field "transitKey", did you mean "transitkey"?`,
+		},
+		{
+			name:      "Unknown field",
+			input:     `{"transitkey": "kleidi", "vaultrole": "kleidi", "address": "http://127.0.0.1:8200", "authmethod": "k8s", "token": "s.xyz"}`,
+			expectErr: `unknown field "token"`,
+		},
+		{
+			name:      "Internal field",
+			input:     `{"transitkey": "kleidi", "vaultrole": "kleidi", "address": "http://127.0.0.1:8200", "authmethod": "k8s", "LatestKeyID": "x"}`,
+			expectErr: `unknown field "LatestKeyID"`,
+		},
+		{
+			name:      "Missing address",
+			input:     `{"transitkey": "kleidi", "vaultrole": "kleidi", "authmethod": "k8s"}`,
+			expectErr: `field "address" is required`,
+		},
+		{
+			name:      "Invalid address",
+			input:     `{"transitkey": "kleidi", "vaultrole": "kleidi", "address": "127.0.0.1:8200", "authmethod": "k8s"}`,
+			expectErr: `field "address" must be an http(s) URL`,
+		},
+		{
+			name:      "Unknown auth method",
+			input:     `{"transitkey": "kleidi", "vaultrole": "kleidi", "address": "http://127.0.0.1:8200", "authmethod": "approle"}`,
+			expectErr: `field "authmethod" set to "approle" is not supported`,
+		},
+		{
+			name:      "Missing role with k8s",
+			input:     `{"transitkey": "kleidi", "address": "http://127.0.0.1:8200", "authmethod": "k8s"}`,
+			expectErr: `field "vaultrole" is required`,
+		},
+		{
+			name:      "Invalid JSON",
+			input:     `{"transitkey": "kleidi",}`,
+			expectErr: `invalid JSON`,
+		},
+	}
+
+	testValidateConfig(t, "hvault", testCases)
+}
+
+func TestHvaultConfigDefaults(t *testing.T) {
+	s, err := readConfig(hvaultProvider, ConfigSource{Inline: []byte(`{"transitkey": "kleidi", "address": "https://vault:8200", "authmethod": "cert"}`)})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if s.AuthPath != "cert" {
+		t.Errorf("expected default auth path cert, but got %s", s.AuthPath)
+	}
+	if s.TransitPath != "transit" {
+		t.Errorf("expected default transit path transit, but got %s", s.TransitPath)
+	}
+}
+
+// Test cases for the softhsm config validation.
+func TestValidatePKCS11Config(t *testing.T) {
+	module := writeConfig(t, "")
+
+	testCases := []configTestCase{
+		{
+			name:  "Valid config",
+			input: `{"tokenLabel": "kleidi-kms-plugin", "pin": "1234", "path": "` + module + `"}`,
+		},
+		{
+			name:      "Unknown field",
+			input:     `{"tokenLabel": "kleidi-kms-plugin", "pin": "1234", "path": "` + module + `", "label": "x"}`,
+			expectErr: `unknown field "label"`,
+		},
+		{
+			name:      "Missing module",
+			input:     `{"tokenLabel": "kleidi-kms-plugin", "pin": "1234", "path": "/nonexistent/libsofthsm2.so"}`,
+			expectErr: `field "path"`,
+		},
+		{
+			name:      "No token",
+			input:     `{"pin": "1234", "path": "` + module + `"}`,
+			expectErr: `exactly one of the fields`,
+		},
+		{
+			name:      "Missing pin",
+			input:     `{"tokenLabel": "kleidi-kms-plugin", "path": "` + module + `"}`,
+			expectErr: `field "pin" is required`,
+		},
+	}
+
+	testValidateConfig(t, "softhsm", testCases)
+}
@@ -1,9 +1,18 @@
 package providers
 
+import "time"
+
 const (
 	keyID         = "kleidi-kms-plugin"
 	annotationKey = "v2.kleidi.beezy.dev"
 	healthOK      = "ok"
 	healthNOK     = "nok"
 	healthy       = "healthy"
+
+	// clusterBindingKey binds the ciphertexts to the cluster name in the cloud
+	// providers, as encryption context or additional authenticated data.
+	clusterBindingKey = "kleidi.beezy.dev/cluster"
+
+	// CloseTimeOut bounds the release of the backend sessions, e.g. on shutdown.
+	CloseTimeOut = 10 * time.Second
 )
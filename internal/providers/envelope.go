@@ -0,0 +1,115 @@
+package providers
+
+import (
+	"encoding/binary"
+	"errors"
+	"maps"
+	"slices"
+
+	"k8s.io/kms/pkg/service"
+)
+
+// envelopeVersion is the version of the envelopes wrapping the outputs of the providers.
+const envelopeVersion = 1
+
+var errTruncatedEnvelope = errors.New("/!\\ truncated envelope")
+
+// appendLengthPrefixed appends v to b, prefixed with its length.
+func appendLengthPrefixed(b, v []byte) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(v))), v...)
+}
+
+// envelopeReader reads the fields of an envelope.
+type envelopeReader struct {
+	data []byte
+}
+
+// readVersion checks the envelope version.
+func (r *envelopeReader) readVersion() error {
+	if len(r.data) == 0 || r.data[0] != envelopeVersion {
+		return errors.New("/!\\ invalid envelope version")
+	}
+	r.data = r.data[1:]
+	return nil
+}
+
+func (r *envelopeReader) readUvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errTruncatedEnvelope
+	}
+	r.data = r.data[n:]
+	return v, nil
+}
+
+// readCount reads a count of items, each taking at least a byte.
+func (r *envelopeReader) readCount() (int, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(r.data)) {
+		return 0, errTruncatedEnvelope
+	}
+	return int(n), nil
+}
+
+func (r *envelopeReader) readLengthPrefixed() ([]byte, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.data)) {
+		return nil, errTruncatedEnvelope
+	}
+	v := r.data[:n]
+	r.data = r.data[n:]
+	return v, nil
+}
+
+// marshalEnvelope encodes the output of a provider: the envelope version, then the
+// KeyID and the annotations, prefixed with their lengths, then the ciphertext.
+func marshalEnvelope(resp *service.EncryptResponse) []byte {
+	b := []byte{envelopeVersion}
+	b = appendLengthPrefixed(b, []byte(resp.KeyID))
+	b = binary.AppendUvarint(b, uint64(len(resp.Annotations)))
+	for _, k := range slices.Sorted(maps.Keys(resp.Annotations)) {
+		b = appendLengthPrefixed(b, []byte(k))
+		b = appendLengthPrefixed(b, resp.Annotations[k])
+	}
+	return append(b, resp.Ciphertext...)
+}
+
+// unmarshalEnvelope decodes the output of marshalEnvelope as a decrypt request.
+func unmarshalEnvelope(data []byte) (*service.DecryptRequest, error) {
+	r := &envelopeReader{data: data}
+	if err := r.readVersion(); err != nil {
+		return nil, err
+	}
+	keyID, err := r.readLengthPrefixed()
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.readCount()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &service.DecryptRequest{KeyID: string(keyID)}
+	if count != 0 {
+		req.Annotations = make(map[string][]byte, count)
+	}
+	for range count {
+		k, err := r.readLengthPrefixed()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.readLengthPrefixed()
+		if err != nil {
+			return nil, err
+		}
+		req.Annotations[string(k)] = v
+	}
+	req.Ciphertext = r.data
+	return req, nil
+}
@@ -0,0 +1,29 @@
+package providers
+
+import (
+	"bytes"
+	"testing"
+
+	"k8s.io/kms/pkg/service"
+)
+
+func TestEnvelope(t *testing.T) {
+	resp := &service.EncryptResponse{
+		Ciphertext:  []byte("ciphertext"),
+		KeyID:       "hsm-key",
+		Annotations: map[string][]byte{annotationKey: []byte("1"), "other.kleidi.beezy.dev": {}},
+	}
+	envelope := marshalEnvelope(resp)
+	req, err := unmarshalEnvelope(envelope)
+	if err != nil {
+		t.Fatalf("unmarshalEnvelope() error: %v", err)
+	}
+	if req.KeyID != resp.KeyID || !bytes.Equal(req.Ciphertext, resp.Ciphertext) || len(req.Annotations) != 2 || string(req.Annotations[annotationKey]) != "1" {
+		t.Fatalf("unmarshalEnvelope() = %v, want %v", req, resp)
+	}
+	for i := range len(envelope) - len(resp.Ciphertext) {
+		if _, err := unmarshalEnvelope(envelope[:i]); err == nil {
+			t.Fatalf("unmarshalEnvelope() of %d bytes succeeded", i)
+		}
+	}
+}
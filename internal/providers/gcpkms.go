@@ -0,0 +1,294 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"github.com/beezy-dev/kleidi/internal/tracing"
+	"go.uber.org/zap"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	gcpkmsProvider = "gcpkms"
+
+	gcpkmsTimeOut = 10 * time.Second
+)
+
+var (
+	gcpkmsKeyNameRegexp = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+	crc32cTable         = crc32.MakeTable(crc32.Castagnoli)
+
+	// gcpkmsCredentialsTypes are the credentials file types accepted, by their "type" field.
+	gcpkmsCredentialsTypes = map[string]option.CredentialsType{
+		"service_account":  option.ServiceAccount,
+		"external_account": option.ExternalAccount,
+	}
+)
+
+var _ service.Service = &gcpkmsRemoteService{}
+
+// gcpkmsConfig is the gcpkms provider configuration.
+type gcpkmsConfig struct {
+	// KeyName is the resource name of the CryptoKey, whose primary version encrypts.
+	KeyName string `json:"keyname"`
+	// ClusterName is bound to the ciphertexts as additional authenticated data.
+	ClusterName string `json:"clustername"`
+	// Endpoint overrides the Cloud KMS endpoint, as host:port.
+	Endpoint string `json:"endpoint"`
+	// CredentialsFile is a service account key or a workload identity federation
+	// configuration, instead of the application default credentials.
+	CredentialsFile string `json:"credentialsfile"`
+	// Insecure connects to Endpoint in plaintext without authentication, for the
+	// emulators and the fake servers only.
+	Insecure bool `json:"insecure"`
+}
+
+func (c *gcpkmsConfig) validate() error {
+	if len(c.KeyName) == 0 {
+		return errors.New("field \"keyname\" is required")
+	}
+	if !gcpkmsKeyNameRegexp.MatchString(c.KeyName) {
+		return fmt.Errorf("field \"keyname\" must be projects/*/locations/*/keyRings/*/cryptoKeys/*, got %q", c.KeyName)
+	}
+	if len(c.ClusterName) == 0 {
+		return errors.New("field \"clustername\" is required")
+	}
+	if c.Insecure && len(c.Endpoint) == 0 {
+		return errors.New("field \"insecure\" requires \"endpoint\"")
+	}
+	if c.Insecure && len(c.CredentialsFile) != 0 {
+		return errors.New("fields \"insecure\" and \"credentialsfile\" are exclusive")
+	}
+	if len(c.CredentialsFile) != 0 {
+		if err := fileExists(c.CredentialsFile); err != nil {
+			return fmt.Errorf("field \"credentialsfile\": %v", err)
+		}
+	}
+	return nil
+}
+
+func readGCPKMSConfig(source ConfigSource) (*gcpkmsConfig, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read gcpkms config: %v", err)
+	}
+	config := &gcpkmsConfig{}
+	if err := decodeStrict(data, config, true); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid gcpkms config %s: %v", source, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid gcpkms config %s: %v", source, err)
+	}
+	return config, nil
+}
+
+// clientOptions returns the options of the Cloud KMS client.
+func (c *gcpkmsConfig) clientOptions() ([]option.ClientOption, error) {
+	var opts []option.ClientOption
+	if len(c.Endpoint) != 0 {
+		opts = append(opts, option.WithEndpoint(c.Endpoint))
+	}
+	if c.Insecure {
+		zap.L().Warn("insecure connection to the Cloud KMS endpoint, for emulators only", logger.Provider(gcpkmsProvider), zap.String("endpoint", c.Endpoint))
+		return append(opts, option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials()))), nil
+	}
+	if len(c.CredentialsFile) != 0 {
+		data, err := os.ReadFile(c.CredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("/!\\ unable to read the credentials file: %v", err)
+		}
+		var file struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("/!\\ invalid credentials file %s: %v", c.CredentialsFile, err)
+		}
+		credentialsType, ok := gcpkmsCredentialsTypes[file.Type]
+		if !ok {
+			return nil, fmt.Errorf("/!\\ credentials file %s of type %q, expecting service_account or external_account", c.CredentialsFile, file.Type)
+		}
+		opts = append(opts, option.WithAuthCredentialsFile(credentialsType, c.CredentialsFile))
+	}
+	return opts, nil
+}
+
+// gcpkmsRemoteService encrypts the data keys with the Encrypt and Decrypt operations
+// of a Cloud KMS CryptoKey. The KeyID is the name of the primary version, so that
+// a rotation of the CryptoKey changes the KeyID reported to the API server.
+type gcpkmsRemoteService struct {
+	client  *kms.KeyManagementClient
+	keyName string
+	aad     []byte
+
+	mu sync.RWMutex
+	// primary is the name of the primary version of the CryptoKey.
+	primary string
+}
+
+// NewGCPKMSRemoteService creates a gcpkms remote service, and reads the primary
+// version of the CryptoKey.
+func NewGCPKMSRemoteService(source ConfigSource) (service.Service, error) {
+	config, err := readGCPKMSConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := config.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gcpkmsTimeOut)
+	defer cancel()
+	client, err := kms.NewKeyManagementClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to initialize Cloud KMS client: %v", err)
+	}
+
+	s := &gcpkmsRemoteService{
+		client:  client,
+		keyName: config.KeyName,
+		aad:     []byte(clusterBindingKey + "=" + config.ClusterName),
+	}
+	if _, err := s.primaryVersion(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	zap.L().Info("INFO: Cloud KMS key found", logger.Provider(gcpkmsProvider), logger.KeyID(s.currentKeyID()))
+	return s, nil
+}
+
+// primaryVersion updates the KeyID to the primary version of the CryptoKey, and returns it.
+func (s *gcpkmsRemoteService) primaryVersion(ctx context.Context) (*kmspb.CryptoKeyVersion, error) {
+	key, err := s.client.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{Name: s.keyName})
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ unable to read the CryptoKey %s: %v", s.keyName, err)
+	}
+	if key.Purpose != kmspb.CryptoKey_ENCRYPT_DECRYPT {
+		return nil, fmt.Errorf("/!\\ CryptoKey %s has the purpose %s, expecting %s", s.keyName, key.Purpose, kmspb.CryptoKey_ENCRYPT_DECRYPT)
+	}
+	if key.Primary == nil {
+		return nil, fmt.Errorf("/!\\ CryptoKey %s has no primary version", s.keyName)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if primary := key.Primary.Name; primary != s.primary {
+		if len(s.primary) != 0 {
+			zap.L().Info("INFO: Cloud KMS key rotated", logger.Provider(gcpkmsProvider), zap.String("from", s.primary), zap.String("to", primary))
+		}
+		s.primary = primary
+	}
+	return key.Primary, nil
+}
+
+func (s *gcpkmsRemoteService) currentKeyID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.primary
+}
+
+// Close closes the connection to Cloud KMS.
+func (s *gcpkmsRemoteService) Close() error {
+	return s.client.Close()
+}
+
+func crc32c(data []byte) *wrapperspb.Int64Value {
+	return wrapperspb.Int64(int64(crc32.Checksum(data, crc32cTable)))
+}
+
+// Encrypt encrypts with the primary version, checking the integrity of the request
+// and the response with their CRC32C checksums.
+func (s *gcpkmsRemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "gcpkms.Encrypt")
+	defer span.End()
+	resp, err := s.client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:                              s.keyName,
+		Plaintext:                         plaintext,
+		PlaintextCrc32C:                   crc32c(plaintext),
+		AdditionalAuthenticatedData:       s.aad,
+		AdditionalAuthenticatedDataCrc32C: crc32c(s.aad),
+	})
+	if err == nil {
+		switch {
+		case !resp.VerifiedPlaintextCrc32C || !resp.VerifiedAdditionalAuthenticatedDataCrc32C:
+			err = errors.New("request corrupted in-transit")
+		case resp.CiphertextCrc32C.GetValue() != crc32c(resp.Ciphertext).GetValue():
+			err = errors.New("response corrupted in-transit")
+		case !strings.HasPrefix(resp.Name, s.keyName+"/cryptoKeyVersions/"):
+			err = fmt.Errorf("encrypted with the unexpected version %q", resp.Name)
+		}
+	}
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ Cloud KMS encrypt failed: %v", err)
+	}
+
+	// the version used, which differs from the cached primary between a rotation and the next Status.
+	return &service.EncryptResponse{
+		Ciphertext: resp.Ciphertext,
+		KeyID:      resp.Name,
+	}, nil
+}
+
+// Decrypt decrypts with the CryptoKey, Cloud KMS selecting the version from the
+// ciphertext, and with the additional authenticated data of the cluster.
+func (s *gcpkmsRemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	if !strings.HasPrefix(req.KeyID, s.keyName+"/cryptoKeyVersions/") {
+		return nil, fmt.Errorf("/!\\ invalid keyID")
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "gcpkms.Decrypt")
+	defer span.End()
+	resp, err := s.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:                              s.keyName,
+		Ciphertext:                        req.Ciphertext,
+		CiphertextCrc32C:                  crc32c(req.Ciphertext),
+		AdditionalAuthenticatedData:       s.aad,
+		AdditionalAuthenticatedDataCrc32C: crc32c(s.aad),
+	})
+	if err == nil && resp.PlaintextCrc32C.GetValue() != crc32c(resp.Plaintext).GetValue() {
+		err = errors.New("response corrupted in-transit")
+	}
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ Cloud KMS decrypt failed: %v", err)
+	}
+	return resp.Plaintext, nil
+}
+
+// Status reads the primary version again, to follow the rotations, and reports it
+// healthy when it is enabled.
+func (s *gcpkmsRemoteService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	healthz := healthOK
+	primary, err := s.primaryVersion(ctx)
+	switch {
+	case err != nil:
+		zap.L().Error("gcpkms: unable to read the key", logger.Provider(gcpkmsProvider), logger.Op("status"), zap.Error(err))
+		healthz = healthNOK
+	case primary.State != kmspb.CryptoKeyVersion_ENABLED:
+		healthz = fmt.Sprintf("version %s is %s", primary.Name, primary.State)
+	}
+
+	return &service.StatusResponse{
+		Version: "v2",
+		Healthz: healthz,
+		KeyID:   s.currentKeyID(),
+	}, nil
+}
@@ -0,0 +1,196 @@
+package providers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kms/pkg/service"
+)
+
+const gcpkmsTestKeyName = "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+
+// gcpkmsFake serves the Cloud KMS operations used by the gcpkms provider. The
+// versions of the CryptoKey encrypt with AES-GCM, the ciphertexts starting with the
+// index of their version.
+type gcpkmsFake struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+
+	mu       sync.Mutex
+	versions []cipher.AEAD
+	state    kmspb.CryptoKeyVersion_CryptoKeyVersionState
+}
+
+func (f *gcpkmsFake) addVersion() {
+	key := make([]byte, 32)
+	rand.Read(key)
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.versions = append(f.versions, aead)
+}
+
+func gcpkmsTestVersion(index int) string {
+	return fmt.Sprintf("%s/cryptoKeyVersions/%d", gcpkmsTestKeyName, index+1)
+}
+
+func (f *gcpkmsFake) GetCryptoKey(ctx context.Context, req *kmspb.GetCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	if req.Name != gcpkmsTestKeyName {
+		return nil, status.Error(codes.NotFound, "CryptoKey not found")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &kmspb.CryptoKey{
+		Name:    gcpkmsTestKeyName,
+		Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT,
+		Primary: &kmspb.CryptoKeyVersion{Name: gcpkmsTestVersion(len(f.versions) - 1), State: f.state},
+	}, nil
+}
+
+func (f *gcpkmsFake) Encrypt(ctx context.Context, req *kmspb.EncryptRequest) (*kmspb.EncryptResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if req.Name != gcpkmsTestKeyName || f.state != kmspb.CryptoKeyVersion_ENABLED {
+		return nil, status.Error(codes.FailedPrecondition, "primary version not enabled")
+	}
+	index := len(f.versions) - 1
+	aead := f.versions[index]
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	ciphertext := aead.Seal(append([]byte{byte(index)}, nonce...), nonce, req.Plaintext, req.AdditionalAuthenticatedData)
+	return &kmspb.EncryptResponse{
+		Name:                    gcpkmsTestVersion(index),
+		Ciphertext:              ciphertext,
+		CiphertextCrc32C:        crc32c(ciphertext),
+		VerifiedPlaintextCrc32C: req.PlaintextCrc32C.GetValue() == crc32c(req.Plaintext).GetValue(),
+		VerifiedAdditionalAuthenticatedDataCrc32C: req.AdditionalAuthenticatedDataCrc32C.GetValue() == crc32c(req.AdditionalAuthenticatedData).GetValue(),
+	}, nil
+}
+
+func (f *gcpkmsFake) Decrypt(ctx context.Context, req *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if req.Name != gcpkmsTestKeyName || len(req.Ciphertext) == 0 || int(req.Ciphertext[0]) >= len(f.versions) {
+		return nil, status.Error(codes.InvalidArgument, "invalid ciphertext")
+	}
+	if req.CiphertextCrc32C.GetValue() != crc32c(req.Ciphertext).GetValue() {
+		return nil, status.Error(codes.InvalidArgument, "ciphertext corrupted")
+	}
+	aead := f.versions[req.Ciphertext[0]]
+	data := req.Ciphertext[1:]
+	if len(data) < aead.NonceSize() {
+		return nil, status.Error(codes.InvalidArgument, "invalid ciphertext")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], req.AdditionalAuthenticatedData)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "decryption failed")
+	}
+	return &kmspb.DecryptResponse{Plaintext: plaintext, PlaintextCrc32C: crc32c(plaintext)}, nil
+}
+
+// startGCPKMSFake serves fake over plaintext gRPC, and returns its address.
+func startGCPKMSFake(t *testing.T, fake *gcpkmsFake) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	kmspb.RegisterKeyManagementServiceServer(server, fake)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func gcpkmsTestConfig(address, clusterName string) ConfigSource {
+	return ConfigSource{Inline: []byte(fmt.Sprintf(`{"keyname": %q, "clustername": %q, "endpoint": %q, "insecure": true}`,
+		gcpkmsTestKeyName, clusterName, address))}
+}
+
+func TestGCPKMSProvider(t *testing.T) {
+	ctx := context.Background()
+	fake := &gcpkmsFake{state: kmspb.CryptoKeyVersion_ENABLED}
+	fake.addVersion()
+	address := startGCPKMSFake(t, fake)
+
+	svc, err := NewGCPKMSRemoteService(gcpkmsTestConfig(address, "prod"))
+	if err != nil {
+		t.Fatalf("NewGCPKMSRemoteService() error: %v", err)
+	}
+	defer Close(svc)
+	status, err := svc.Status(ctx)
+	if err != nil || status.Healthz != healthOK || status.KeyID != gcpkmsTestVersion(0) {
+		t.Fatalf("Status() = %v, %v", status, err)
+	}
+	before, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil || before.KeyID != gcpkmsTestVersion(0) {
+		t.Fatalf("Encrypt() = %v, %v", before, err)
+	}
+
+	// a new primary version changes the KeyID.
+	fake.addVersion()
+	status, err = svc.Status(ctx)
+	if err != nil || status.Healthz != healthOK || status.KeyID != gcpkmsTestVersion(1) {
+		t.Fatalf("Status() after rotation = %v, %v", status, err)
+	}
+	after, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil || after.KeyID != gcpkmsTestVersion(1) {
+		t.Fatalf("Encrypt() after rotation = %v, %v", after, err)
+	}
+	for _, resp := range []*service.EncryptResponse{before, after} {
+		plaintext, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: resp.Ciphertext, KeyID: resp.KeyID})
+		if err != nil || string(plaintext) != "secret" {
+			t.Fatalf("Decrypt(%s) = %q, %v", resp.KeyID, plaintext, err)
+		}
+	}
+	if _, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{
+		Ciphertext: after.Ciphertext,
+		KeyID:      "projects/p/locations/l/keyRings/r/cryptoKeys/other/cryptoKeyVersions/1",
+	}); err == nil {
+		t.Fatal("Decrypt() with the KeyID of another key succeeded")
+	}
+
+	// the ciphertexts are bound to the cluster.
+	other, err := NewGCPKMSRemoteService(gcpkmsTestConfig(address, "staging"))
+	if err != nil {
+		t.Fatalf("NewGCPKMSRemoteService() error: %v", err)
+	}
+	defer Close(other)
+	if _, err := other.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: after.Ciphertext, KeyID: after.KeyID}); err == nil {
+		t.Fatal("Decrypt() by another cluster succeeded")
+	}
+
+	fake.mu.Lock()
+	fake.state = kmspb.CryptoKeyVersion_DISABLED
+	fake.mu.Unlock()
+	if status, _ := svc.Status(ctx); !strings.Contains(status.Healthz, "is DISABLED") {
+		t.Fatalf("Status() with a disabled version = %v", status)
+	}
+}
+
+func TestValidateGCPKMSConfig(t *testing.T) {
+	credentials := writeConfig(t, `{"type": "service_account"}`)
+	testCases := []configTestCase{
+		{name: "Application default credentials", input: `{"keyname": "` + gcpkmsTestKeyName + `", "clustername": "prod"}`},
+		{name: "Credentials file", input: `{"keyname": "` + gcpkmsTestKeyName + `", "clustername": "prod", "credentialsfile": "` + credentials + `"}`},
+		{name: "Emulator", input: `{"keyname": "` + gcpkmsTestKeyName + `", "clustername": "prod", "endpoint": "localhost:9011", "insecure": true}`},
+		{name: "Key version instead of key", input: `{"keyname": "` + gcpkmsTestKeyName + `/cryptoKeyVersions/1", "clustername": "prod"}`, expectErr: `field "keyname" must be`},
+		{name: "Missing cluster name", input: `{"keyname": "` + gcpkmsTestKeyName + `"}`, expectErr: `field "clustername" is required`},
+		{name: "Insecure without endpoint", input: `{"keyname": "` + gcpkmsTestKeyName + `", "clustername": "prod", "insecure": true}`, expectErr: `field "insecure" requires "endpoint"`},
+		{name: "Insecure with credentials", input: `{"keyname": "` + gcpkmsTestKeyName + `", "clustername": "prod", "endpoint": "localhost:9011", "insecure": true, "credentialsfile": "` + credentials + `"}`, expectErr: `are exclusive`},
+	}
+
+	testValidateConfig(t, "gcpkms", testCases)
+}
@@ -7,6 +7,15 @@ import (
 	certauth  "github.com/hashicorp/vault/api/auth/cert"
 )
 
+// authMethods are the supported values of the hvault "authmethod" field.
+var authMethods = []string{"k8s", "cert"}
+
+// defaultAuthPaths are the default mount paths of the auth methods.
+var defaultAuthPaths = map[string]string{
+	"k8s":  "kubernetes",
+	"cert": "cert",
+}
+
 func getK8sAuth(roleName string, mountPath string) (hvaultapi.AuthMethod, error) {
 	return k8sauth.NewKubernetesAuth(
 		roleName,
@@ -8,20 +8,26 @@ package providers
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"net/url"
+	"slices"
 	"strconv"
 	"time"
 
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"github.com/beezy-dev/kleidi/internal/metrics"
+	"github.com/beezy-dev/kleidi/internal/tracing"
 	"github.com/hashicorp/vault/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/kms/pkg/service"
 	"go.uber.org/zap"
 )
 
 const (
 	retrySleep = 150*time.Millisecond
+	hvaultProvider = "hvault"
 )
 
 var _ service.Service = &hvaultRemoteService{}
@@ -29,8 +35,11 @@ var _ service.Service = &hvaultRemoteService{}
 type hvaultRemoteService struct {
 	*api.Client
 	ClientAuthMethod api.AuthMethod
+	// provider is hvault or openbao, labelling the logs and the metrics.
+	provider string
 
 	LatestKeyID string
+	// The json tagged fields are read from the configuration file.
 	Namespace   string `json:"namespace"`
 	Transitkey  string `json:"transitkey"`
 	Vaultrole   string `json:"vaultrole"`
@@ -38,97 +47,152 @@ type hvaultRemoteService struct {
 	AuthPath    string `json:"authpath"`
 	TransitPath string `json:"transitpath"`
 	AuthMethod  string `json:"authmethod"`
+	// RevokeToken revokes the Vault token when the provider instance is closed.
+	RevokeToken bool `json:"revoketoken"`
 }
 
-func fatalOrErr(err error) error {
-	// it can happen that token gets ivalidated - shutdown in these cases
-	// for others it just "flows through"
+// validate checks the configuration against the hvault schema and sets the default mount paths.
+func (c *hvaultRemoteService) validate() error {
+	if len(c.Address) == 0 {
+		return errors.New("field \"address\" is required")
+	}
+	if u, err := url.Parse(c.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("field \"address\" must be an http(s) URL, got %q", c.Address)
+	}
+	if len(c.Transitkey) == 0 {
+		return errors.New("field \"transitkey\" is required")
+	}
+	if !slices.Contains(authMethods, c.AuthMethod) {
+		return fmt.Errorf("field \"authmethod\" set to %q is not supported. Only %v are valid options", c.AuthMethod, authMethods)
+	}
+	if c.AuthMethod == "k8s" && len(c.Vaultrole) == 0 {
+		return errors.New("field \"vaultrole\" is required with the k8s auth method")
+	}
+	if c.AuthPath == "" {
+		c.AuthPath = defaultAuthPaths[c.AuthMethod]
+	}
+	if c.TransitPath == "" {
+		c.TransitPath = "transit"
+	}
+	return nil
+}
+
+// hvaultLogger returns the global logger tagged with the hvault provider field.
+func hvaultLogger() *zap.Logger {
+	return zap.L().With(logger.Provider(hvaultProvider))
+}
+
+// logger returns the global logger tagged with the provider field of s.
+func (s *hvaultRemoteService) logger() *zap.Logger {
+	return zap.L().With(logger.Provider(s.provider))
+}
+
+// tokenOrErr flags the errors of a token invalidated despite the re-login attempts,
+// the others just "flow through". The error is returned, never fatal: a reload keeps
+// the current instance, the dry-run reports it and a running instance turns unhealthy.
+func tokenOrErr(err error) error {
 	wrappedErr := WrapVaultError(err.Error())
 	if errors.Is(wrappedErr, ErrInvalidToken) {
-		zap.L().Fatal("EXIT:token: invalid token, restarting.")
-		return err		
+		hvaultLogger().Error("token: invalid token, re-login needed", zap.Error(err))
+		return fmt.Errorf("/!\\ invalid Vault token, re-login needed: %v", err)
 	}
 	return err
 }
 
-func readConfig(configFilePath string) *hvaultRemoteService {
-	data, err := os.ReadFile(configFilePath)
+// readConfig reads the configuration of provider, hvault or openbao, which share the same schema.
+func readConfig(provider string, source ConfigSource) (*hvaultRemoteService, error) {
+	data, err := source.Read()
 	if err != nil {
-		zap.L().Fatal("EXIT:ctx: failed to read vault config file with error: " + err.Error())
+		return nil, fmt.Errorf("/!\\ failed to read vault config: %v", err)
 	}
-	vaultService := &hvaultRemoteService{}
-	err = json.Unmarshal(([]byte(data)), &vaultService)
-	if err != nil {
-		zap.L().Fatal("EXIT:ctx: invalid JSON config file: " + err.Error())
+	// only the json tagged fields are accepted, with their exact names.
+	vaultService := &hvaultRemoteService{provider: provider}
+	if err := decodeStrict(data, vaultService, true); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid %s config %s: %v", provider, source, err)
 	}
-	if vaultService.TransitPath == "" {
-		vaultService.TransitPath = "transit"
+	if err := vaultService.validate(); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid %s config %s: %v", provider, source, err)
 	}
-	return vaultService
+	return vaultService, nil
 }
 
-func setupClient(cfg *api.Config, ns string, method string, roleName string, mountPath string) (*api.Client, api.AuthMethod) {	
+func setupClient(cfg *api.Config, ns string, method string, roleName string, mountPath string) (*api.Client, api.AuthMethod, error) {
 	authMethod, err := createAuthMethod(method, roleName, mountPath)
 	if err != nil {
-		zap.L().Fatal("EXIT:client: failed to create auth method: " + err.Error())
+		return nil, nil, fmt.Errorf("/!\\ failed to create auth method %s: %v", method, err)
 	}
 	client, err := api.NewClient(cfg)
 	if err != nil {
-		zap.L().Fatal("EXIT:client: failed to initialize Vault client with error: " + err.Error())
+		return nil, nil, fmt.Errorf("/!\\ failed to initialize Vault client: %v", err)
 	}
 	client.SetNamespace(ns)
 	authInfo, err := client.Auth().Login(context.Background(), authMethod)
 	if err != nil {
-		zap.L().Fatal("EXIT:authInfo: unable to log in with error:" + err.Error())
+		return nil, nil, fmt.Errorf("/!\\ unable to log in with auth method %s: %v", method, err)
 	}
 	if authInfo == nil {
-		zap.L().Fatal("EXIT:authInfo: no auth info was returned after login")
+		return nil, nil, fmt.Errorf("/!\\ no auth info was returned after login with auth method %s", method)
 	}
-	return client, authMethod
+	return client, authMethod, nil
 }
 
-func NewVaultClientRemoteService(configFilePath string) (service.Service, error) {
-	vaultService := readConfig(configFilePath)
+func NewVaultClientRemoteService(source ConfigSource) (service.Service, error) {
+	vaultService, err := readConfig(hvaultProvider, source)
+	if err != nil {
+		return nil, err
+	}
 	vaultconfig := api.DefaultConfig()
 	vaultconfig.Address = vaultService.Address
 
-	zap.L().Debug("Config loaded:", zap.String("Vault address", vaultService.Address),
-		zap.String("Transit key name", vaultService.Transitkey),
-		zap.String("Vault role", vaultService.Vaultrole),
-		zap.String("Vault namespace", vaultService.Namespace),
-		zap.String("Auth method", vaultService.AuthMethod),
-		zap.String("Auth mount path", vaultService.AuthPath),
-		zap.String("Transit engine mount path", vaultService.TransitPath),
+	if err := vaultService.connect(vaultconfig); err != nil {
+		return nil, err
+	}
+	return vaultService, nil
+}
+
+// connect logs in to the server with the configured auth method, then reads the transit key and checks the token.
+func (s *hvaultRemoteService) connect(vaultconfig *api.Config) error {
+	var err error
+	s.logger().Debug("Config loaded", zap.String("address", s.Address),
+		zap.String("transitkey", s.Transitkey),
+		zap.String("vaultrole", s.Vaultrole),
+		zap.String("namespace", s.Namespace),
+		zap.String("authmethod", s.AuthMethod),
+		zap.String("authpath", s.AuthPath),
+		zap.String("transitpath", s.TransitPath),
 	)
 	// setup client with selected auth method
-	vaultService.Client, vaultService.ClientAuthMethod = setupClient(vaultconfig,
-		vaultService.Namespace,
-		vaultService.AuthMethod, 
-		vaultService.Vaultrole, 
-		vaultService.AuthPath)
+	s.Client, s.ClientAuthMethod, err = setupClient(vaultconfig,
+		s.Namespace,
+		s.AuthMethod,
+		s.Vaultrole,
+		s.AuthPath)
+	if err != nil {
+		return err
+	}
 
 	// obtain latest version of the transit key and create a key ID for it
-	key, err := vaultService.GetTransitKey(context.Background())
+	key, err := s.GetTransitKey(context.Background())
 	if err != nil {
-		zap.L().Fatal("ERROR:key: unable to find transit key, restarting: " + err.Error())
+		return fmt.Errorf("/!\\ unable to find transit key %s: %v", s.Transitkey, err)
 	}
-	vaultService.LatestKeyID = createLatestTransitKeyId(key)
-	zap.L().Info("Received key ID on startup: " + vaultService.LatestKeyID)
+	s.LatestKeyID = createLatestTransitKeyId(key)
+	s.logger().Info("Received key ID on startup", logger.KeyID(s.LatestKeyID))
 
 	// initial token check
-	err = vaultService.CheckTokenValidity(context.Background())
+	err = s.CheckTokenValidity(context.Background())
 	if err != nil {
-		zap.L().Fatal("EXIT:token: could not check token validity: " + err.Error())
+		return fmt.Errorf("/!\\ could not check token validity: %v", err)
 	}
 
-	return vaultService, nil
+	return nil
 }
 
 func (s *hvaultRemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
-	zap.L().Debug("Received encrypt request with UID: " + uid)
+	s.logger().Debug("Received encrypt request", logger.Op("encrypt"), logger.UID(uid))
 	enresult, err := s.encrypt(ctx, plaintext)
 	if err != nil {
-		zap.L().Error("enresult: invalid response")
+		s.logger().Error("enresult: invalid response", logger.Op("encrypt"), logger.UID(uid))
 		return nil, errors.New("Invalid response")
 	}
 
@@ -146,25 +210,25 @@ func (s *hvaultRemoteService) encrypt(ctx context.Context, plaintext []byte) ([]
 	encodepayload := map[string]interface{}{
 		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
 	}
-	encrypt, err := retryVaultOp(s, ctx, 3, retrySleep, func()(*api.Secret, error){
+	encrypt, err := retryVaultOp(s, ctx, "encrypt", 3, retrySleep, func(ctx context.Context)(*api.Secret, error){
 		return s.Client.Logical().WriteWithContext(ctx, enckeypath, encodepayload)
 	})
 	if err != nil {
-		zap.L().Error("encrypt: error: " + err.Error())
-		return nil, fatalOrErr(err)
+		s.logger().Error("encrypt: error", logger.Op("encrypt"), zap.Error(err))
+		return nil, tokenOrErr(err)
 	}
 	enresult, ok := encrypt.Data["ciphertext"].(string)
 	if !ok {
-		zap.L().Error("enresult: invalid response")
+		s.logger().Error("enresult: invalid response", logger.Op("encrypt"))
 		return nil, errors.New("Invalid response")
 	}
 	return []byte(enresult), nil
 }
 
 func (s *hvaultRemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
-	zap.L().Debug("Received decrypt request with UID: " + uid)
+	s.logger().Debug("Received decrypt request", logger.Op("decrypt"), logger.UID(uid), logger.KeyID(req.KeyID))
 	if len(req.Annotations) != 1 {
-		zap.L().Error("len:annotations: " + fmt.Sprintf("%v", req.Annotations))
+		s.logger().Error("len:annotations: invalid annotations", logger.Op("decrypt"), logger.UID(uid), zap.Any("annotations", req.Annotations))
 		return nil, fmt.Errorf("/!\\ invalid annotations")
 	}
 	if v, ok := req.Annotations[annotationKey]; !ok || string(v) != "1" {
@@ -178,21 +242,21 @@ func (s *hvaultRemoteService) decrypt(ctx context.Context, ciphertext []byte) ([
 	encryptedPayload := map[string]interface{}{
 		"ciphertext": string(ciphertext),
 	}
-	encryptedResponse, err := retryVaultOp(s, ctx, 3, retrySleep, func()(*api.Secret, error){
+	encryptedResponse, err := retryVaultOp(s, ctx, "decrypt", 3, retrySleep, func(ctx context.Context)(*api.Secret, error){
 		return s.Logical().WriteWithContext(ctx, decryptkeypath, encryptedPayload)
 	})
 	if err != nil {
-		zap.L().Error("encryptedResponse: with error: " + err.Error())
-		return nil, fatalOrErr(err)
+		s.logger().Error("encryptedResponse: error", logger.Op("decrypt"), zap.Error(err))
+		return nil, tokenOrErr(err)
 	}
 	response, ok := encryptedResponse.Data["plaintext"].(string)
 	if !ok {
-		zap.L().Error("response: invalid response")
+		s.logger().Error("response: invalid response", logger.Op("decrypt"))
 		return nil, errors.New("response: invalid response")
 	}
 	decodepayload, err := base64.StdEncoding.DecodeString(response)
 	if err != nil {
-		zap.L().Error("decodepayload: with error: " + err.Error())
+		s.logger().Error("decodepayload: error", logger.Op("decrypt"), zap.Error(err))
 		return nil, err
 	}
 	return decodepayload, nil
@@ -202,16 +266,16 @@ func (s *hvaultRemoteService) Status(ctx context.Context) (*service.StatusRespon
 	// get transit key, obtain the latest version of the transit key
 	key, err := s.GetTransitKey(ctx)
 	if err != nil {
-		zap.L().Error("ERROR:key: unable to find transit key: " + err.Error())
+		s.logger().Error("ERROR:key: unable to find transit key", logger.Op("status"), zap.String("transitkey", s.Transitkey), zap.Error(err))
 		return s.createStatusResponse(healthNOK), err
 	}
 	// extract the latest and create key id for it
 	s.LatestKeyID = createLatestTransitKeyId(key)
-	zap.L().Debug("Key ID updated to: " + s.LatestKeyID)
+	s.logger().Debug("Key ID updated", logger.Op("status"), logger.KeyID(s.LatestKeyID))
 	// do healthcheck
 	err = s.Health(ctx)
 	if err != nil {
-		zap.L().Error("ERROR:Status: unhealthy: " + err.Error())
+		s.logger().Error("ERROR:Status: unhealthy", logger.Op("status"), logger.KeyID(s.LatestKeyID), zap.Error(err))
 		return s.createStatusResponse(healthNOK), err
 	}
 	// all OK
@@ -227,7 +291,7 @@ func (s *hvaultRemoteService) Health(ctx context.Context) error {
 	// check Encryption as Service functionality (transit)
 	enc, err := s.encrypt(ctx, []byte(healthy))
 	if err != nil {
-		zap.L().Error("Health: encrypt failed: " + err.Error())
+		s.logger().Error("Health: encrypt failed", logger.Op("status"), zap.Error(err))
 		return err
 	}
 	dec, err := s.decrypt(ctx, enc)
@@ -238,7 +302,7 @@ func (s *hvaultRemoteService) Health(ctx context.Context) error {
 	if healthy != string(dec) {
 		return errors.New("Health check failed: decrypt does not match")
 	}
-	zap.L().Info("Health: Health check OK")
+	s.logger().Info("Health: Health check OK", logger.Op("status"))
 	return nil
 }
 
@@ -253,18 +317,18 @@ func (s *hvaultRemoteService) createStatusResponse(healthz string) *service.Stat
 
 func (s *hvaultRemoteService) GetTransitKey(ctx context.Context) (*api.Secret, error) {
 	// retry read 3x with 150 millisec delay between them
-	key, err := retryVaultOp(s, ctx, 3, retrySleep, func()(*api.Secret, error){
+	key, err := retryVaultOp(s, ctx, "keyread", 3, retrySleep, func(ctx context.Context)(*api.Secret, error){
 		return s.Client.Logical().ReadWithContext(ctx, fmt.Sprintf("%s/keys/%s", s.TransitPath, s.Transitkey))
 	})
 	if err != nil {
-		return nil, fatalOrErr(err)
-	}
-	zap.L().Debug("Got transit key: " + fmt.Sprintf("%v", map[string]interface{}{
-		"latest_version":         key.Data["latest_version"],
-		"min_available_version":  key.Data["min_available_version"],
-		"min_encryption_version": key.Data["min_encryption_version"],
-		"min_decryption_version": key.Data["min_decryption_version"],
-		"auto_rotate_period":     key.Data["auto_rotate_period"]}))
+		return nil, tokenOrErr(err)
+	}
+	s.logger().Debug("Got transit key", zap.String("transitkey", s.Transitkey),
+		zap.Any("latest_version", key.Data["latest_version"]),
+		zap.Any("min_available_version", key.Data["min_available_version"]),
+		zap.Any("min_encryption_version", key.Data["min_encryption_version"]),
+		zap.Any("min_decryption_version", key.Data["min_decryption_version"]),
+		zap.Any("auto_rotate_period", key.Data["auto_rotate_period"]))
 	return key, nil
 }
 
@@ -284,11 +348,11 @@ func (s *hvaultRemoteService) GetVaultToken(ctx context.Context) (*api.Secret, e
 	// requires policy to have: "auth/token/lookup-self read and "auth/token/renew-self" update
 	path := fmt.Sprintf("auth/token/lookup-self")
 
-	token, err := retryVaultOp(s, ctx, 3, retrySleep, func()(*api.Secret, error){
+	token, err := retryVaultOp(s, ctx, "tokenlookup", 3, retrySleep, func(ctx context.Context)(*api.Secret, error){
 		return s.Client.Logical().ReadWithContext(ctx, path)
 	})
 	if err != nil {
-		return nil, fatalOrErr(err)
+		return nil, tokenOrErr(err)
 	}
 	return token, nil
 }
@@ -296,85 +360,111 @@ func (s *hvaultRemoteService) GetVaultToken(ctx context.Context) (*api.Secret, e
 func (s *hvaultRemoteService) CheckTokenValidity(ctx context.Context) error {
 	token, err := s.GetVaultToken(ctx)
 	if err != nil {
-		zap.L().Error("Token: could not get token: " + err.Error())
+		s.logger().Error("Token: could not get token", zap.Error(err))
 		return err
 	}
 
 	creation_ttl, _ := strconv.Atoi(fmt.Sprintf("%s", token.Data["creation_ttl"]))
 	ttl, _ := strconv.Atoi(fmt.Sprintf("%s", token.Data["ttl"]))
-
-	zap.L().Debug("Token: " + fmt.Sprintf("%v", map[string]interface{}{
-		"creation_ttl":     creation_ttl,
-		"issue_time":       token.Data["issue_time"],
-		"expire_time":      token.Data["expire_time"],
-		"explicit_max_ttl": token.Data["explicit_max_ttl"],
-		"ttl":              ttl,
-	}))
+	metrics.TokenTTL.WithLabelValues(s.provider).Set(float64(ttl))
+
+	s.logger().Debug("Token",
+		zap.Int("creation_ttl", creation_ttl),
+		zap.Any("issue_time", token.Data["issue_time"]),
+		zap.Any("expire_time", token.Data["expire_time"]),
+		zap.Any("explicit_max_ttl", token.Data["explicit_max_ttl"]),
+		zap.Int("ttl", ttl),
+	)
 
 	if ttl <= 0 || ttl > creation_ttl {
 		// token has been tampered with
 		// also happens if you've modify role's ttl by hand
-		// To wait (return Error) or not to wait (Fatal)?
-		zap.L().Fatal("EXIT:token: invalid ttl, re-login needed")
+		s.logger().Error("token: invalid ttl, re-login needed", zap.Int("creation_ttl", creation_ttl), zap.Int("ttl", ttl))
+		return fmt.Errorf("/!\\ invalid Vault token ttl %d, creation ttl %d, re-login needed", ttl, creation_ttl)
 	}
 	// renew the token if it reached it's validity periods about 2/3rd
 	if float32(ttl) <= float32(creation_ttl)-(float32(creation_ttl)*0.667) {
 		// renew the token
-		zap.L().Debug("Token near expiry, renewing the token.")
+		s.logger().Debug("Token near expiry, renewing the token.", zap.Int("ttl", ttl))
 		err = s.RenewOwnToken(ctx, creation_ttl)
 		if err != nil {
-			zap.L().Error("Token renew failed: " + err.Error())
+			s.logger().Error("Token renew failed", zap.Error(err))
 			return errors.New("Token renew failed.")
 		}
-		zap.L().Info("Token renew successful.")
+		s.logger().Info("Token renew successful.")
 		return nil
 	}
 	// no need for token renew
-	zap.L().Debug("No need for token renew.")
+	s.logger().Debug("No need for token renew.", zap.Int("ttl", ttl))
 	return nil
 }
 
 func (s *hvaultRemoteService) RenewOwnToken(ctx context.Context, creation_ttl int) error {
 	// renews with the original creation_ttl
 	path := fmt.Sprintf("auth/token/renew-self")
-	_, err := retryVaultOp(s, ctx, 3, retrySleep, func()(*api.Secret, error){
+	_, err := retryVaultOp(s, ctx, "tokenrenew", 3, retrySleep, func(ctx context.Context)(*api.Secret, error){
 		return s.Client.Logical().WriteWithContext(ctx, path, 
 			map[string]any{"data": map[string]any{
 				"ttl":       fmt.Sprintf("%d", creation_ttl),
 				"renewable": "true"}})
 	})
 	if err != nil {
-		return fatalOrErr(err)
+		return tokenOrErr(err)
 	}
 	return nil
 }
 
-func retryVaultOp[T any](s *hvaultRemoteService, ctx context.Context, amount int, sleepTime time.Duration, f func()(T, error)) (result T, err error) {
+// Close revokes the Vault token when configured, so that it does not outlive the
+// provider instance on shutdown or configuration reload.
+func (s *hvaultRemoteService) Close() error {
+	if !s.RevokeToken {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), CloseTimeOut)
+	defer cancel()
+	if err := s.Client.Auth().Token().RevokeSelfWithContext(ctx, ""); err != nil {
+		return fmt.Errorf("/!\\ unable to revoke the Vault token: %v", err)
+	}
+	s.logger().Info("INFO: Vault token revoked")
+	return nil
+}
+
+func retryVaultOp[T any](s *hvaultRemoteService, ctx context.Context, op string, amount int, sleepTime time.Duration, f func(context.Context)(T, error)) (result T, err error) {
 	// Retries operation f() "amount", times, with "sleepTime" in between them.
 	// If operation cannot be performed due to e.g. expired login, try to login in again and retry.
 	// Applicable wherever read/write call to Vault is performed.
+	// Each attempt is recorded as a span named after op, parent of the Vault calls made by f().
 	for i := 0; i < amount; i++ {
+		if i > 0 {
+			metrics.BackendRetries.WithLabelValues(s.provider).Inc()
+		}
 		select {
 		case <-ctx.Done():
 			return result, ctx.Err()
 		default:
-			result, err = f()
+			attemptCtx, span := tracing.Tracer().Start(ctx, s.provider+"."+op, trace.WithAttributes(
+				attribute.Int("hvault.attempt", i+1),
+				attribute.String("hvault.transit_key", s.Transitkey),
+			))
+			result, err = f(attemptCtx)
+			tracing.RecordError(span, err)
+			span.End()
 			if err != nil {
-				zap.L().Error("Got error: " + err.Error())
+				s.logger().Error("Got error", logger.Op(op), zap.Int("attempt", i+1), zap.Error(err))
 				wrappedErr := WrapVaultError(err.Error())
 				if errors.Is(wrappedErr, ErrInvalidToken) {
 					// re-login
 					_, err := s.Client.Auth().Login(ctx, s.ClientAuthMethod)
 					if err != nil {
-						zap.L().Error("Error: Could not relogin: " + err.Error())
+						s.logger().Error("Error: Could not relogin", logger.Op(op), zap.Error(err))
 					} else {
 						// relogin OK
-						zap.L().Debug("Relogin succesful.")
+						s.logger().Debug("Relogin succesful.", logger.Op(op))
 					}
 				} // other error that cannot be solved by relogin: try calling f() again
 			} else {
 				// no error, no need to retry
-				zap.L().Debug("Operation succeded on attempt " + fmt.Sprintf("%d", i+1))
+				s.logger().Debug("Operation succeded", logger.Op(op), zap.Int("attempt", i+1))
 				return result, nil
 			}
 			time.Sleep(sleepTime)
@@ -0,0 +1,517 @@
+package providers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	kekcacheProvider = "kekcache"
+	// kekSize is the size of the AES-256 intermediate KEKs.
+	kekSize = 32
+
+	kekcacheDefaultTTL        = time.Hour
+	kekcacheDefaultRevalidate = 5 * time.Minute
+	// kekcacheSweepInterval bounds the period between two sweeps of the expired KEKs.
+	kekcacheSweepInterval = 30 * time.Second
+)
+
+var _ service.Service = &kekcacheRemoteService{}
+
+var errKEKCacheClosed = errors.New("/!\\ kekcache provider closed")
+
+// kekcacheConfig is the kekcache provider configuration.
+type kekcacheConfig struct {
+	// Backend is the provider wrapping the intermediate KEKs.
+	Backend memberConfig `json:"backend"`
+	// TTL bounds the use of a KEK to encrypt, and the caching of an unwrapped KEK to
+	// decrypt, defaulting to 1h.
+	TTL string `json:"ttl"`
+	// Revalidate is the interval between the unwrapping of the current KEK by the
+	// backend, defaulting to 5m.
+	Revalidate string `json:"revalidate"`
+
+	ttl        time.Duration
+	revalidate time.Duration
+}
+
+func (c *kekcacheConfig) validate() error {
+	if len(c.Backend.Name) == 0 {
+		c.Backend.Name = "backend"
+	}
+	if err := c.Backend.validate(); err != nil {
+		return fmt.Errorf("field \"backend\": %v", err)
+	}
+
+	var err error
+	c.ttl, c.revalidate = kekcacheDefaultTTL, kekcacheDefaultRevalidate
+	if len(c.TTL) != 0 {
+		if c.ttl, err = time.ParseDuration(c.TTL); err != nil || c.ttl <= 0 {
+			return fmt.Errorf("field \"ttl\" must be a positive duration, got %q", c.TTL)
+		}
+	}
+	if len(c.Revalidate) != 0 {
+		if c.revalidate, err = time.ParseDuration(c.Revalidate); err != nil || c.revalidate <= 0 {
+			return fmt.Errorf("field \"revalidate\" must be a positive duration, got %q", c.Revalidate)
+		}
+	}
+	if c.revalidate >= c.ttl {
+		return fmt.Errorf("field \"revalidate\" set to %s must be shorter than the ttl %s", c.revalidate, c.ttl)
+	}
+	return nil
+}
+
+func readKEKCacheConfig(source ConfigSource) (*kekcacheConfig, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read kekcache config: %v", err)
+	}
+	config := &kekcacheConfig{}
+	if err := decodeStrict(data, config, true); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid kekcache config %s: %v", source, err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err == nil && len(raw["backend"]) != 0 {
+		if err := checkKeys(raw["backend"], &memberConfig{}); err != nil {
+			return nil, fmt.Errorf("/!\\ invalid kekcache config %s: field \"backend\": %v", source, err)
+		}
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid kekcache config %s: %v", source, err)
+	}
+	return config, nil
+}
+
+// kekSlots holds the KEKs in a page of locked memory.
+type kekSlots struct {
+	mem  []byte
+	free [][]byte
+}
+
+func newKEKSlots() (*kekSlots, error) {
+	mem, err := allocLocked(os.Getpagesize())
+	if err != nil {
+		return nil, err
+	}
+	s := &kekSlots{mem: mem}
+	for i := 0; i+kekSize <= len(mem); i += kekSize {
+		s.free = append(s.free, mem[i:i+kekSize:i+kekSize])
+	}
+	return s, nil
+}
+
+func (s *kekSlots) get() []byte {
+	if len(s.free) == 0 {
+		return nil
+	}
+	slot := s.free[len(s.free)-1]
+	s.free = s.free[:len(s.free)-1]
+	return slot
+}
+
+// put zeroes slot and makes it available again.
+func (s *kekSlots) put(slot []byte) {
+	clear(slot)
+	s.free = append(s.free, slot)
+}
+
+// cachedKEK is an unwrapped KEK.
+type cachedKEK struct {
+	// key is a slot of locked memory.
+	key []byte
+	// wrapped is the KEK wrapped by the backend, as a decrypt request.
+	wrapped *service.DecryptRequest
+	expires time.Time
+}
+
+// kekcacheRemoteService encrypts the data keys locally with AES-GCM, using an
+// intermediate KEK generated at random and wrapped by the backend. The wrapped KEK
+// is stored in each ciphertext, so that the KEKs unwrapped by the backend are
+// cached to decrypt, in locked memory and for a limited time. The KeyID is the
+// KeyID of the backend, which stays authoritative for the rotations.
+type kekcacheRemoteService struct {
+	backend    member
+	ttl        time.Duration
+	revalidate time.Duration
+	now        func() time.Time
+
+	mu    sync.Mutex
+	slots *kekSlots
+	// keks are the cached KEKs, by hash of their wrapped envelope.
+	keks map[[sha256.Size]byte]*cachedKEK
+	// current is the KEK encrypting, with its wrapped envelope and the KeyID of the backend.
+	current         *cachedKEK
+	currentEnvelope []byte
+	currentKeyID    string
+	validated       time.Time
+	// closed is set once the locked memory is released, and done is closed with it.
+	closed bool
+	done   chan struct{}
+}
+
+// NewKEKCacheRemoteService creates a kekcache remote service, building its backend
+// and wrapping a first KEK with it.
+func NewKEKCacheRemoteService(source ConfigSource) (service.Service, error) {
+	config, err := readKEKCacheConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	slots, err := newKEKSlots()
+	if err != nil {
+		return nil, err
+	}
+	backends, err := newMembers([]memberConfig{config.Backend})
+	if err != nil {
+		freeLocked(slots.mem)
+		return nil, err
+	}
+
+	s := &kekcacheRemoteService{
+		backend:    backends[0],
+		ttl:        config.ttl,
+		revalidate: config.revalidate,
+		now:        time.Now,
+		slots:      slots,
+		keks:       map[[sha256.Size]byte]*cachedKEK{},
+		done:       make(chan struct{}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dryRunTimeOut)
+	defer cancel()
+	key, resp, err := s.wrapKEK(ctx)
+	if err == nil {
+		s.mu.Lock()
+		err = s.installLocked(key, resp)
+		s.mu.Unlock()
+		clear(key)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	go s.run(min(s.ttl, kekcacheSweepInterval))
+	zap.L().Info("INFO: kekcache provider ready", logger.Provider(kekcacheProvider), logger.KeyID(s.currentKeyID),
+		zap.String("backend", config.Backend.Provider), zap.Duration("ttl", s.ttl), zap.Duration("revalidate", s.revalidate))
+	return s, nil
+}
+
+// Close zeroes the KEKs, releases the locked memory and closes the backend.
+func (s *kekcacheRemoteService) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.purgeLocked()
+	err := freeLocked(s.slots.mem)
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+	return errors.Join(err, closeMembers([]member{s.backend}))
+}
+
+// purgeLocked zeroes and drops all the KEKs.
+func (s *kekcacheRemoteService) purgeLocked() {
+	for id, kek := range s.keks {
+		s.slots.put(kek.key)
+		delete(s.keks, id)
+	}
+	s.current, s.currentEnvelope, s.currentKeyID = nil, nil, ""
+}
+
+// run sweeps the expired KEKs every interval until the provider is closed, so that
+// they do not stay in memory past their ttl when Status is not called.
+func (s *kekcacheRemoteService) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if !s.closed {
+				s.sweepLocked()
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// storeLocked caches key, evicting the KEK expiring first when the slots are full.
+// It returns nil when only the current KEK can not be evicted, key staying uncached.
+func (s *kekcacheRemoteService) storeLocked(id [sha256.Size]byte, key []byte, wrapped *service.DecryptRequest) *cachedKEK {
+	if kek, ok := s.keks[id]; ok {
+		kek.expires = s.now().Add(s.ttl)
+		return kek
+	}
+	slot := s.slots.get()
+	if slot == nil {
+		var evict [sha256.Size]byte
+		var first *cachedKEK
+		for id, kek := range s.keks {
+			if kek != s.current && (first == nil || kek.expires.Before(first.expires)) {
+				evict, first = id, kek
+			}
+		}
+		if first == nil {
+			return nil
+		}
+		s.slots.put(first.key)
+		delete(s.keks, evict)
+		slot = s.slots.get()
+	}
+	copy(slot, key)
+	kek := &cachedKEK{key: slot, wrapped: wrapped, expires: s.now().Add(s.ttl)}
+	s.keks[id] = kek
+	return kek
+}
+
+// sweepLocked zeroes and drops the expired KEKs.
+func (s *kekcacheRemoteService) sweepLocked() {
+	now := s.now()
+	for id, kek := range s.keks {
+		if !now.Before(kek.expires) {
+			if kek == s.current {
+				s.current, s.currentEnvelope, s.currentKeyID = nil, nil, ""
+			}
+			s.slots.put(kek.key)
+			delete(s.keks, id)
+		}
+	}
+}
+
+// wrapKEK generates a new KEK and wraps it with the backend. It is a round-trip to
+// the backend, made without holding the lock.
+func (s *kekcacheRemoteService) wrapKEK(ctx context.Context) ([]byte, *service.EncryptResponse, error) {
+	key := make([]byte, kekSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.backend.service.Encrypt(ctx, "", key)
+	if err != nil {
+		clear(key)
+		return nil, nil, fmt.Errorf("/!\\ unable to wrap the KEK with the backend: %v", err)
+	}
+	return key, resp, nil
+}
+
+// installLocked makes key, wrapped by the backend as resp, the current KEK.
+func (s *kekcacheRemoteService) installLocked(key []byte, resp *service.EncryptResponse) error {
+	envelope := marshalEnvelope(resp)
+	wrapped, err := unmarshalEnvelope(envelope)
+	if err != nil {
+		return err
+	}
+	// the previous KEK is no longer current, and can be evicted for the new one.
+	s.current, s.currentEnvelope, s.currentKeyID = nil, nil, ""
+	kek := s.storeLocked(sha256.Sum256(envelope), key, wrapped)
+	if kek == nil {
+		return errors.New("/!\\ no slot of locked memory left for the KEK")
+	}
+	s.current = kek
+	s.currentEnvelope = envelope
+	s.currentKeyID = resp.KeyID
+	s.validated = s.now()
+	zap.L().Info("INFO: kekcache KEK generated", logger.Provider(kekcacheProvider), logger.KeyID(resp.KeyID))
+	return nil
+}
+
+// rotationDueLocked reports whether the current KEK is missing or expired.
+func (s *kekcacheRemoteService) rotationDueLocked() bool {
+	return s.current == nil || !s.now().Before(s.current.expires)
+}
+
+// sealKEK encrypts plaintext with a KEK, authenticated with keyID, without keeping
+// the AES key schedule beyond the call.
+func sealKEK(key []byte, keyID string, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return sealAEAD(aead, keyID, plaintext)
+}
+
+func openKEK(key []byte, keyID string, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return openAEAD(aead, keyID, data)
+}
+
+// Encrypt seals plaintext with the current KEK, generating a new one when it expired
+// or the KeyID of the backend changed. The ciphertext is the envelope version, the
+// wrapped KEK prefixed with its length, then the sealed data key.
+func (s *kekcacheRemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errKEKCacheClosed
+	}
+	if s.rotationDueLocked() {
+		// the backend is called unlocked, so that Decrypt does not wait on it.
+		s.mu.Unlock()
+		key, resp, err := s.wrapKEK(ctx)
+		s.mu.Lock()
+		if err != nil {
+			return nil, err
+		}
+		defer clear(key)
+		if s.closed {
+			return nil, errKEKCacheClosed
+		}
+		// another Encrypt may have rotated meanwhile: its KEK is kept.
+		if s.rotationDueLocked() {
+			if err := s.installLocked(key, resp); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sealed, err := sealKEK(s.current.key, s.currentKeyID, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := appendLengthPrefixed([]byte{envelopeVersion}, s.currentEnvelope)
+	return &service.EncryptResponse{
+		Ciphertext: append(ciphertext, sealed...),
+		KeyID:      s.currentKeyID,
+	}, nil
+}
+
+// Decrypt opens the data key with the cached KEK of the ciphertext, unwrapping it
+// with the backend when it is not cached or expired.
+func (s *kekcacheRemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	r := &envelopeReader{data: req.Ciphertext}
+	if err := r.readVersion(); err != nil {
+		return nil, err
+	}
+	envelope, err := r.readLengthPrefixed()
+	if err != nil {
+		return nil, err
+	}
+	sealed := r.data
+	id := sha256.Sum256(envelope)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errKEKCacheClosed
+	}
+	if kek, ok := s.keks[id]; ok && s.now().Before(kek.expires) {
+		defer s.mu.Unlock()
+		return openKEK(kek.key, req.KeyID, sealed)
+	}
+	s.mu.Unlock()
+
+	wrapped, err := unmarshalEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.backend.service.Decrypt(ctx, uid, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ unable to unwrap the KEK with the backend: %v", err)
+	}
+	defer clear(key)
+	if len(key) != kekSize {
+		return nil, fmt.Errorf("/!\\ unwrapped KEK of %d bytes", len(key))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errKEKCacheClosed
+	}
+	kek := s.storeLocked(id, key, wrapped)
+	if kek == nil {
+		return openKEK(key, req.KeyID, sealed)
+	}
+	return openKEK(kek.key, req.KeyID, sealed)
+}
+
+// Status reports the Status of the backend. A change of its KeyID retires the current
+// KEK, and every revalidate interval the backend unwraps the current KEK again: a
+// failure drops all the KEKs.
+func (s *kekcacheRemoteService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	status, err := s.backend.service.Status(ctx)
+	if err != nil {
+		zap.L().Error("kekcache: backend status failed", logger.Provider(kekcacheProvider), logger.Op("status"), zap.Error(err))
+		status = &service.StatusResponse{Version: "v2", Healthz: healthNOK}
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errKEKCacheClosed
+	}
+	s.sweepLocked()
+	if s.current != nil && len(status.KeyID) != 0 && status.KeyID != s.currentKeyID {
+		zap.L().Info("INFO: kekcache backend rotated, retiring the KEK", logger.Provider(kekcacheProvider),
+			zap.String("from", s.currentKeyID), zap.String("to", status.KeyID))
+		s.current, s.currentEnvelope, s.currentKeyID = nil, nil, ""
+	}
+	var current *cachedKEK
+	if s.current != nil && !s.now().Before(s.validated.Add(s.revalidate)) {
+		current = s.current
+	}
+	s.mu.Unlock()
+
+	healthz := status.Healthz
+	if current != nil {
+		if err := s.revalidateKEK(ctx, current); err != nil {
+			zap.L().Error("kekcache: KEK revalidation failed, dropping the KEKs", logger.Provider(kekcacheProvider), logger.Op("status"), zap.Error(err))
+			healthz = fmt.Sprintf("KEK revalidation failed: %v", err)
+		}
+	}
+
+	return &service.StatusResponse{
+		Version: "v2",
+		Healthz: healthz,
+		KeyID:   status.KeyID,
+	}, nil
+}
+
+// revalidateKEK unwraps kek again with the backend, and drops all the KEKs when the
+// backend refuses it or returns another key.
+func (s *kekcacheRemoteService) revalidateKEK(ctx context.Context, kek *cachedKEK) error {
+	key, err := s.backend.service.Decrypt(ctx, "", kek.wrapped)
+	defer clear(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != kek {
+		// rotated or purged meanwhile.
+		return nil
+	}
+	if err == nil && subtle.ConstantTimeCompare(key, kek.key) != 1 {
+		err = errors.New("backend unwrapped another KEK")
+	}
+	if err != nil {
+		s.purgeLocked()
+		return err
+	}
+	s.validated = s.now()
+	return nil
+}
@@ -0,0 +1,252 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/kms/pkg/service"
+)
+
+// countingService counts the operations reaching an unavailableService.
+type countingService struct {
+	*unavailableService
+	encrypts atomic.Int32
+	decrypts atomic.Int32
+}
+
+func (s *countingService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	s.encrypts.Add(1)
+	return s.unavailableService.Encrypt(ctx, uid, plaintext)
+}
+
+func (s *countingService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	s.decrypts.Add(1)
+	return s.unavailableService.Decrypt(ctx, uid, req)
+}
+
+// newKEKCacheTest returns a kekcache provider with a localkey backend in dir, made
+// unavailable on demand, and a clock advanced by the test.
+func newKEKCacheTest(t *testing.T, dir string) (*kekcacheRemoteService, *countingService, *time.Time) {
+	t.Helper()
+	built, err := NewKEKCacheRemoteService(ConfigSource{Inline: []byte(fmt.Sprintf(`{"backend": %s, "ttl": "1h", "revalidate": "5m"}`,
+		localkeyMember(dir, "hsm")))})
+	if err != nil {
+		t.Fatalf("NewKEKCacheRemoteService() error: %v", err)
+	}
+	t.Cleanup(func() { Close(built) })
+
+	svc := built.(*kekcacheRemoteService)
+	backend := &countingService{unavailableService: &unavailableService{Service: svc.backend.service}}
+	svc.backend.service = backend
+	now := time.Now()
+	svc.mu.Lock()
+	svc.now = func() time.Time { return now }
+	svc.mu.Unlock()
+	return svc, backend, &now
+}
+
+func TestKEKCacheProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	svc, backend, now := newKEKCacheTest(t, dir)
+
+	status, err := svc.Status(ctx)
+	if err != nil || status.Healthz != healthOK || !strings.HasPrefix(status.KeyID, localkeyPrefix) {
+		t.Fatalf("Status() = %v, %v", status, err)
+	}
+	decrypt := func(svc service.Service, resp *service.EncryptResponse) {
+		t.Helper()
+		plaintext, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: resp.Ciphertext, KeyID: resp.KeyID, Annotations: resp.Annotations})
+		if err != nil || string(plaintext) != "secret" {
+			t.Fatalf("Decrypt() = %q, %v", plaintext, err)
+		}
+	}
+
+	// the data keys are encrypted and decrypted locally with the KEK.
+	first, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil || first.KeyID != status.KeyID {
+		t.Fatalf("Encrypt() = %v, %v", first, err)
+	}
+	decrypt(svc, first)
+	if backend.encrypts.Load() != 0 || backend.decrypts.Load() != 0 {
+		t.Fatalf("backend called %d/%d times, want none", backend.encrypts.Load(), backend.decrypts.Load())
+	}
+
+	// after a restart, the backend unwraps the KEK once.
+	restarted, restartedBackend, _ := newKEKCacheTest(t, dir)
+	decrypt(restarted, first)
+	decrypt(restarted, first)
+	if n := restartedBackend.decrypts.Load(); n != 1 {
+		t.Fatalf("backend unwrapped %d times after a restart, want 1", n)
+	}
+
+	// the backend revalidates the KEK.
+	*now = now.Add(6 * time.Minute)
+	if status, _ := svc.Status(ctx); status.Healthz != healthOK || backend.decrypts.Load() != 1 {
+		t.Fatalf("Status() = %v after %d unwraps, want a revalidation", status, backend.decrypts.Load())
+	}
+
+	// past the ttl, a new KEK encrypts, and the former one is unwrapped again.
+	*now = now.Add(time.Hour)
+	second, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil || backend.encrypts.Load() != 1 {
+		t.Fatalf("Encrypt() after the ttl = %v, %v", second, err)
+	}
+	decrypt(svc, first)
+	if n := backend.decrypts.Load(); n != 2 {
+		t.Fatalf("backend unwrapped %d times, want 2", n)
+	}
+
+	// the rotation of the backend retires the KEK.
+	if err := os.WriteFile(filepath.Join(dir, "hsm", "zz-rotated.key"), bytes.Repeat([]byte{1}, localkeySize), 0600); err != nil {
+		t.Fatal(err)
+	}
+	status, err = svc.Status(ctx)
+	if err != nil || status.KeyID != localkeyPrefix+"zz-rotated" {
+		t.Fatalf("Status() after rotation = %v, %v", status, err)
+	}
+	if rotated, err := svc.Encrypt(ctx, "uid", []byte("secret")); err != nil || rotated.KeyID != status.KeyID {
+		t.Fatalf("Encrypt() after rotation = %v, %v", rotated, err)
+	}
+
+	// a failed revalidation drops the KEKs.
+	backend.down.Store(true)
+	*now = now.Add(6 * time.Minute)
+	if status, _ := svc.Status(ctx); !strings.HasPrefix(status.Healthz, "KEK revalidation failed") {
+		t.Fatalf("Status() with the backend down = %v", status)
+	}
+	if _, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: second.Ciphertext, KeyID: second.KeyID}); err == nil {
+		t.Fatal("Decrypt() with the backend down succeeded")
+	}
+
+	Close(svc)
+	if _, err := svc.Encrypt(ctx, "uid", []byte("secret")); err == nil {
+		t.Fatal("Encrypt() after Close() succeeded")
+	}
+}
+
+// blockingService holds the Encrypt calls until release is closed.
+type blockingService struct {
+	service.Service
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	close(s.entered)
+	<-s.release
+	return s.Service.Encrypt(ctx, uid, plaintext)
+}
+
+func TestKEKCacheRotationUnlocked(t *testing.T) {
+	ctx := context.Background()
+	svc, backend, now := newKEKCacheTest(t, t.TempDir())
+	first, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+
+	// past the ttl, Decrypt does not wait on the backend wrapping a new KEK.
+	blocking := &blockingService{Service: backend, entered: make(chan struct{}), release: make(chan struct{})}
+	svc.backend.service = blocking
+	*now = now.Add(time.Hour)
+	req := &service.DecryptRequest{Ciphertext: first.Ciphertext, KeyID: first.KeyID}
+	done := make(chan error, 1)
+	go func() {
+		_, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+		done <- err
+	}()
+	<-blocking.entered
+
+	decrypted := make(chan error, 1)
+	go func() {
+		_, err := svc.Decrypt(ctx, "uid", req)
+		decrypted <- err
+	}()
+	select {
+	case err := <-decrypted:
+		if err != nil {
+			t.Fatalf("Decrypt() during the rotation error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		close(blocking.release)
+		<-done
+		t.Fatal("Decrypt() blocked by the rotation")
+	}
+	close(blocking.release)
+	if err := <-done; err != nil {
+		t.Fatalf("Encrypt() after the ttl error: %v", err)
+	}
+}
+
+func TestKEKCacheSlotsFull(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	svc, _, _ := newKEKCacheTest(t, dir)
+	first, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+
+	// every slot is taken, and only the current KEK is cached: the KEK of first is
+	// unwrapped without being cached.
+	restarted, backend, _ := newKEKCacheTest(t, dir)
+	restarted.mu.Lock()
+	restarted.slots.free = nil
+	restarted.mu.Unlock()
+	for range 2 {
+		plaintext, err := restarted.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: first.Ciphertext, KeyID: first.KeyID})
+		if err != nil || string(plaintext) != "secret" {
+			t.Fatalf("Decrypt() with the slots full = %q, %v", plaintext, err)
+		}
+	}
+	if n := backend.decrypts.Load(); n != 2 {
+		t.Fatalf("backend unwrapped %d times, want 2", n)
+	}
+}
+
+func TestKEKCacheSweep(t *testing.T) {
+	built, err := NewKEKCacheRemoteService(ConfigSource{Inline: []byte(fmt.Sprintf(`{"backend": %s, "ttl": "200ms", "revalidate": "100ms"}`,
+		localkeyMember(t.TempDir(), "hsm")))})
+	if err != nil {
+		t.Fatalf("NewKEKCacheRemoteService() error: %v", err)
+	}
+	defer Close(built)
+	svc := built.(*kekcacheRemoteService)
+
+	// the KEK expires without any Status call, and is swept.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		svc.mu.Lock()
+		cached := len(svc.keks)
+		svc.mu.Unlock()
+		if cached == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d KEKs cached past their ttl", cached)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestValidateKEKCacheConfig(t *testing.T) {
+	hsm := localkeyMember(t.TempDir(), "hsm")
+	testCases := []configTestCase{
+		{name: "Valid config", input: `{"backend": ` + hsm + `, "ttl": "24h", "revalidate": "10m"}`},
+		{name: "Defaults", input: `{"backend": {"provider": "localkey", "config": {"keydir": "/opt/kleidi/keys"}}}`},
+		{name: "Missing backend", input: `{"ttl": "1h"}`, expectErr: `field "provider" set to "" is not supported`},
+		{name: "Invalid ttl", input: `{"backend": ` + hsm + `, "ttl": "1 day"}`, expectErr: `field "ttl" must be a positive duration`},
+		{name: "Revalidate above ttl", input: `{"backend": ` + hsm + `, "ttl": "5m", "revalidate": "10m"}`, expectErr: `must be shorter than the ttl`},
+		{name: "Typo in backend field name", input: `{"backend": {"provider": "localkey", "configFile": "/tmp/x"}}`, expectErr: `did you mean "configfile"?`},
+	}
+
+	testValidateConfig(t, "kekcache", testCases)
+}
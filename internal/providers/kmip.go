@@ -0,0 +1,623 @@
+package providers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"github.com/beezy-dev/kleidi/internal/metrics"
+	"github.com/beezy-dev/kleidi/internal/tracing"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	kmipProvider = "kmip"
+	kmipPrefix   = "kmip-"
+
+	// kmipServerLayout annotates the ciphertexts encrypted by the KMIP server:
+	// the nonce, the encrypted data and the GCM tag.
+	kmipServerLayout = "kmip.1"
+	kmipNonceSize    = 12
+	kmipTagSize      = 16
+
+	kmipTimeOut = 10 * time.Second
+)
+
+// The kmip provider modes: server encrypts with the KMIP Encrypt and Decrypt
+// operations, local retrieves the key with Get and encrypts locally. auto selects
+// server when the server offers Encrypt and Decrypt, local otherwise.
+const (
+	kmipModeAuto   = "auto"
+	kmipModeServer = "server"
+	kmipModeLocal  = "local"
+)
+
+var kmipModes = []string{kmipModeAuto, kmipModeServer, kmipModeLocal}
+
+// The KMIP 1.4 enumerations used by the kmip provider.
+const (
+	kmipOperationLocate        uint32 = 0x08
+	kmipOperationGet           uint32 = 0x0A
+	kmipOperationGetAttributes uint32 = 0x0B
+	kmipOperationQuery         uint32 = 0x18
+	kmipOperationEncrypt       uint32 = 0x1F
+	kmipOperationDecrypt       uint32 = 0x20
+
+	kmipQueryOperations           uint32 = 0x01
+	kmipObjectTypeSymmetricKey    uint32 = 0x02
+	kmipNameTypeUninterpretedText uint32 = 0x01
+	kmipKeyFormatTypeRaw          uint32 = 0x01
+	kmipCryptographicAlgorithmAES uint32 = 0x03
+	kmipBlockCipherModeGCM        uint32 = 0x09
+	kmipResultStatusSuccess       uint32 = 0x00
+	kmipStateActive               uint32 = 0x02
+	kmipProtocolVersionMajor      int32  = 1
+	kmipProtocolVersionMinor      int32  = 4
+)
+
+var kmipOperationNames = map[uint32]string{
+	kmipOperationLocate:        "Locate",
+	kmipOperationGet:           "Get",
+	kmipOperationGetAttributes: "GetAttributes",
+	kmipOperationQuery:         "Query",
+	kmipOperationEncrypt:       "Encrypt",
+	kmipOperationDecrypt:       "Decrypt",
+}
+
+var kmipStateNames = map[uint32]string{
+	0x01: "PreActive",
+	0x02: "Active",
+	0x03: "Deactivated",
+	0x04: "Compromised",
+	0x05: "Destroyed",
+	0x06: "DestroyedCompromised",
+}
+
+var _ service.Service = &kmipRemoteService{}
+
+// kmipConfig is the kmip provider configuration.
+type kmipConfig struct {
+	// Address is the host:port of the KMIP server, usually on port 5696.
+	Address string `json:"address"`
+	// ServerName overrides the server name verified in the server certificate.
+	ServerName string `json:"servername"`
+	// CACert verifies the server certificate instead of the system roots.
+	CACert string `json:"cacert"`
+	// ClientCert and ClientKey authenticate kleidi to the KMIP server.
+	ClientCert string `json:"clientcert"`
+	ClientKey  string `json:"clientkey"`
+	// KeyName is the Name attribute of the active AES key to use.
+	KeyName string `json:"keyname"`
+	// Mode is auto, server or local, defaulting to auto.
+	Mode string `json:"mode"`
+}
+
+func (c *kmipConfig) validate() error {
+	if len(c.Address) == 0 {
+		return errors.New("field \"address\" is required")
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("field \"address\" must be host:port, got %q", c.Address)
+	}
+	if len(c.ClientCert) == 0 || len(c.ClientKey) == 0 {
+		return errors.New("fields \"clientcert\" and \"clientkey\" are required")
+	}
+	for field, path := range map[string]string{"cacert": c.CACert, "clientcert": c.ClientCert, "clientkey": c.ClientKey} {
+		if len(path) == 0 {
+			continue
+		}
+		if err := fileExists(path); err != nil {
+			return fmt.Errorf("field %q: %v", field, err)
+		}
+	}
+	if len(c.KeyName) == 0 {
+		return errors.New("field \"keyname\" is required")
+	}
+	if c.Mode == "" {
+		c.Mode = kmipModeAuto
+	}
+	if !slices.Contains(kmipModes, c.Mode) {
+		return fmt.Errorf("field \"mode\" set to %q is not supported. Only %v are valid options", c.Mode, kmipModes)
+	}
+	return nil
+}
+
+func readKMIPConfig(source ConfigSource) (*kmipConfig, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read kmip config: %v", err)
+	}
+	config := &kmipConfig{}
+	if err := decodeStrict(data, config, true); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid kmip config %s: %v", source, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid kmip config %s: %v", source, err)
+	}
+	return config, nil
+}
+
+// tlsConfig returns the mutual TLS configuration of the KMIP connection.
+func (c *kmipConfig) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ unable to load the KMIP client certificate: %v", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ServerName:   c.ServerName,
+	}
+	if len(c.CACert) != 0 {
+		pem, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("/!\\ unable to read the KMIP CA certificate: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("/!\\ no certificate found in %s", c.CACert)
+		}
+	}
+	return config, nil
+}
+
+// kmipClient sends KMIP 1.4 requests on a mutual TLS connection, one at a time.
+type kmipClient struct {
+	address   string
+	tlsConfig *tls.Config
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// call sends a single batch item request and returns the response payload. The
+// request is sent again once on a new connection when the connection fails.
+func (c *kmipClient) call(ctx context.Context, operation uint32, payload ...kmipItem) (kmipItem, error) {
+	request := kmipStruct(kmipTagRequestMessage,
+		kmipStruct(kmipTagRequestHeader,
+			kmipStruct(kmipTagProtocolVersion,
+				kmipInt(kmipTagProtocolVersionMajor, kmipProtocolVersionMajor),
+				kmipInt(kmipTagProtocolVersionMinor, kmipProtocolVersionMinor)),
+			kmipInt(kmipTagBatchCount, 1)),
+		kmipStruct(kmipTagBatchItem,
+			kmipEnum(kmipTagOperation, operation),
+			kmipStruct(kmipTagRequestPayload, payload...)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var response kmipItem
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 {
+			metrics.BackendRetries.WithLabelValues(kmipProvider).Inc()
+		}
+		if response, err = c.roundTrip(ctx, request); err == nil {
+			break
+		}
+		// a response may still be in flight on the connection: it is never reused
+		// after a failure, so that it is not read as the response of the next request.
+		c.closeConn()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return kmipItem{}, fmt.Errorf("/!\\ KMIP %s request failed: %v", kmipOperationNames[operation], err)
+	}
+
+	batchItem, ok := response.child(kmipTagBatchItem)
+	if !ok {
+		return kmipItem{}, fmt.Errorf("/!\\ KMIP %s response without batch item", kmipOperationNames[operation])
+	}
+	if status, ok := batchItem.enum(kmipTagResultStatus); !ok || status != kmipResultStatusSuccess {
+		reason, _ := batchItem.enum(kmipTagResultReason)
+		return kmipItem{}, fmt.Errorf("/!\\ KMIP %s failed with status %d, reason %#x: %s",
+			kmipOperationNames[operation], status, reason, batchItem.text(kmipTagResultMessage))
+	}
+	responsePayload, _ := batchItem.child(kmipTagResponsePayload)
+	return responsePayload, nil
+}
+
+func (c *kmipClient) roundTrip(ctx context.Context, request kmipItem) (kmipItem, error) {
+	if c.conn == nil {
+		dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: kmipTimeOut}, Config: c.tlsConfig}
+		conn, err := dialer.DialContext(ctx, "tcp", c.address)
+		if err != nil {
+			return kmipItem{}, err
+		}
+		c.conn = conn
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(kmipTimeOut)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return kmipItem{}, err
+	}
+	if err := writeKMIP(c.conn, request); err != nil {
+		return kmipItem{}, err
+	}
+	response, err := readKMIP(c.conn)
+	if err != nil {
+		return kmipItem{}, err
+	}
+	if response.Tag != kmipTagResponseMessage {
+		return kmipItem{}, fmt.Errorf("unexpected message tag %06X", response.Tag)
+	}
+	return response, nil
+}
+
+func (c *kmipClient) closeConn() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Close closes the connection to the KMIP server.
+func (c *kmipClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeConn()
+}
+
+// operations returns the operations supported by the server.
+func (c *kmipClient) operations(ctx context.Context) ([]uint32, error) {
+	payload, err := c.call(ctx, kmipOperationQuery, kmipEnum(kmipTagQueryFunction, kmipQueryOperations))
+	if err != nil {
+		return nil, err
+	}
+	var operations []uint32
+	for _, item := range payload.children(kmipTagOperation) {
+		if v, ok := item.Value.(uint32); ok {
+			operations = append(operations, v)
+		}
+	}
+	return operations, nil
+}
+
+// locate returns the unique identifiers of the active symmetric keys named name.
+func (c *kmipClient) locate(ctx context.Context, name string) ([]string, error) {
+	payload, err := c.call(ctx, kmipOperationLocate,
+		kmipStruct(kmipTagAttribute,
+			kmipText(kmipTagAttributeName, "Object Type"),
+			kmipEnum(kmipTagAttributeValue, kmipObjectTypeSymmetricKey)),
+		kmipStruct(kmipTagAttribute,
+			kmipText(kmipTagAttributeName, "Name"),
+			kmipStruct(kmipTagAttributeValue,
+				kmipText(kmipTagNameValue, name),
+				kmipEnum(kmipTagNameType, kmipNameTypeUninterpretedText))),
+		kmipStruct(kmipTagAttribute,
+			kmipText(kmipTagAttributeName, "State"),
+			kmipEnum(kmipTagAttributeValue, kmipStateActive)))
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, item := range payload.children(kmipTagUniqueIdentifier) {
+		if v, ok := item.Value.(string); ok {
+			uids = append(uids, v)
+		}
+	}
+	return uids, nil
+}
+
+// state returns the State attribute of the object uid.
+func (c *kmipClient) state(ctx context.Context, uid string) (uint32, error) {
+	payload, err := c.call(ctx, kmipOperationGetAttributes,
+		kmipText(kmipTagUniqueIdentifier, uid),
+		kmipText(kmipTagAttributeName, "State"))
+	if err != nil {
+		return 0, err
+	}
+	for _, attribute := range payload.children(kmipTagAttribute) {
+		if attribute.text(kmipTagAttributeName) != "State" {
+			continue
+		}
+		if state, ok := attribute.enum(kmipTagAttributeValue); ok {
+			return state, nil
+		}
+	}
+	return 0, fmt.Errorf("/!\\ KMIP object %s has no State attribute", uid)
+}
+
+// get returns the raw material of the AES key uid.
+func (c *kmipClient) get(ctx context.Context, uid string) ([]byte, error) {
+	payload, err := c.call(ctx, kmipOperationGet,
+		kmipText(kmipTagUniqueIdentifier, uid),
+		kmipEnum(kmipTagKeyFormatType, kmipKeyFormatTypeRaw))
+	if err != nil {
+		return nil, err
+	}
+	key, _ := payload.child(kmipTagSymmetricKey)
+	keyBlock, _ := key.child(kmipTagKeyBlock)
+	if algorithm, _ := keyBlock.enum(kmipTagCryptographicAlgorithm); algorithm != kmipCryptographicAlgorithmAES {
+		return nil, fmt.Errorf("/!\\ KMIP object %s is not an AES key", uid)
+	}
+	keyValue, _ := keyBlock.child(kmipTagKeyValue)
+	material := keyValue.bytes(kmipTagKeyMaterial)
+	if len(material) == 0 {
+		return nil, fmt.Errorf("/!\\ KMIP object %s returned without raw key material", uid)
+	}
+	return material, nil
+}
+
+func kmipGCMParameters() kmipItem {
+	return kmipStruct(kmipTagCryptographicParameters,
+		kmipEnum(kmipTagBlockCipherMode, kmipBlockCipherModeGCM),
+		kmipEnum(kmipTagCryptographicAlgorithm, kmipCryptographicAlgorithmAES),
+		kmipInt(kmipTagTagLength, kmipTagSize),
+		kmipBool(kmipTagRandomIV, true))
+}
+
+// encrypt encrypts plaintext with the AES-GCM key uid and the additional data ad,
+// and returns nonce || ciphertext || tag.
+func (c *kmipClient) encrypt(ctx context.Context, uid string, plaintext, ad []byte) ([]byte, error) {
+	payload, err := c.call(ctx, kmipOperationEncrypt,
+		kmipText(kmipTagUniqueIdentifier, uid),
+		kmipGCMParameters(),
+		kmipBytes(kmipTagData, plaintext),
+		kmipBytes(kmipTagAuthenticatedEncryptionAD, ad))
+	if err != nil {
+		return nil, err
+	}
+	nonce, data, tag := payload.bytes(kmipTagIVCounterNonce), payload.bytes(kmipTagData), payload.bytes(kmipTagAuthenticatedEncryptionTag)
+	if len(nonce) != kmipNonceSize || len(tag) != kmipTagSize {
+		return nil, fmt.Errorf("/!\\ KMIP Encrypt returned a %d bytes nonce and a %d bytes tag, expecting %d and %d",
+			len(nonce), len(tag), kmipNonceSize, kmipTagSize)
+	}
+	return slices.Concat(nonce, data, tag), nil
+}
+
+// decrypt decrypts the output of encrypt.
+func (c *kmipClient) decrypt(ctx context.Context, uid string, ciphertext, ad []byte) ([]byte, error) {
+	if len(ciphertext) < kmipNonceSize+kmipTagSize {
+		return nil, fmt.Errorf("/!\\ stored data was shorter than the required size")
+	}
+	tagStart := len(ciphertext) - kmipTagSize
+	payload, err := c.call(ctx, kmipOperationDecrypt,
+		kmipText(kmipTagUniqueIdentifier, uid),
+		kmipGCMParameters(),
+		kmipBytes(kmipTagData, ciphertext[kmipNonceSize:tagStart]),
+		kmipBytes(kmipTagIVCounterNonce, ciphertext[:kmipNonceSize]),
+		kmipBytes(kmipTagAuthenticatedEncryptionAD, ad),
+		kmipBytes(kmipTagAuthenticatedEncryptionTag, ciphertext[tagStart:]))
+	if err != nil {
+		return nil, err
+	}
+	return payload.bytes(kmipTagData), nil
+}
+
+// kmipRemoteService encrypts with an AES key of a KMIP server, located by name.
+// The KeyID is the unique identifier of the key, so that a new active key under
+// the same name rotates the key reported to the API server.
+type kmipRemoteService struct {
+	client  *kmipClient
+	keyName string
+	mode    string
+
+	mu      sync.RWMutex
+	current string
+	// keys caches the AES-GCM of the retrieved keys in local mode, by KeyID.
+	keys map[string]cipher.AEAD
+}
+
+// NewKMIPRemoteService creates a kmip remote service, authenticated by its client
+// certificate, and locates the active key of the configured name.
+func NewKMIPRemoteService(source ConfigSource) (service.Service, error) {
+	config, err := readKMIPConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &kmipRemoteService{
+		client:  &kmipClient{address: config.Address, tlsConfig: tlsConfig},
+		keyName: config.KeyName,
+		mode:    config.Mode,
+		keys:    make(map[string]cipher.AEAD),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kmipTimeOut)
+	defer cancel()
+	if err := s.init(ctx); err != nil {
+		s.client.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *kmipRemoteService) init(ctx context.Context) error {
+	if s.mode == kmipModeAuto {
+		operations, err := s.client.operations(ctx)
+		if err != nil {
+			return err
+		}
+		s.mode = kmipModeLocal
+		if slices.Contains(operations, kmipOperationEncrypt) && slices.Contains(operations, kmipOperationDecrypt) {
+			s.mode = kmipModeServer
+		}
+	}
+	if err := s.locateKey(ctx); err != nil {
+		return err
+	}
+	if s.mode == kmipModeLocal {
+		if _, err := s.localKey(ctx, s.current); err != nil {
+			return err
+		}
+	}
+	zap.L().Info("INFO: kmip key located", logger.Provider(kmipProvider),
+		zap.String("keyname", s.keyName), logger.KeyID(s.current), zap.String("mode", s.mode))
+	return nil
+}
+
+// locateKey sets the current KeyID to the active key of the configured name.
+func (s *kmipRemoteService) locateKey(ctx context.Context) error {
+	uids, err := s.client.locate(ctx, s.keyName)
+	if err != nil {
+		return err
+	}
+	if len(uids) == 0 {
+		return fmt.Errorf("/!\\ no active KMIP symmetric key named %q", s.keyName)
+	}
+	if len(uids) > 1 {
+		zap.L().Warn("several active KMIP keys with the same name, using the first one returned by the server",
+			logger.Provider(kmipProvider), zap.String("keyname", s.keyName), zap.Strings("uids", uids))
+	}
+	keyID := kmipPrefix + uids[0]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if keyID != s.current {
+		if len(s.current) != 0 {
+			zap.L().Info("INFO: kmip key rotated", logger.Provider(kmipProvider), zap.String("from", s.current), zap.String("to", keyID))
+		}
+		s.current = keyID
+	}
+	return nil
+}
+
+// localKey returns the AES-GCM of keyID, retrieving the key on first use.
+func (s *kmipRemoteService) localKey(ctx context.Context, keyID string) (cipher.AEAD, error) {
+	s.mu.RLock()
+	aead, ok := s.keys[keyID]
+	s.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	material, err := s.client.get(ctx, strings.TrimPrefix(keyID, kmipPrefix))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(material)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ invalid KMIP key %s: %v", keyID, err)
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[keyID] = aead
+	return aead, nil
+}
+
+// Close closes the connection to the KMIP server.
+func (s *kmipRemoteService) Close() error {
+	return s.client.Close()
+}
+
+func (s *kmipRemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	s.mu.RLock()
+	keyID := s.current
+	s.mu.RUnlock()
+
+	ctx, span := tracing.Tracer().Start(ctx, "kmip.Encrypt")
+	defer span.End()
+
+	var cipherText []byte
+	var annotations map[string][]byte
+	var err error
+	if s.mode == kmipModeServer {
+		cipherText, err = s.client.encrypt(ctx, strings.TrimPrefix(keyID, kmipPrefix), plaintext, []byte(keyID))
+		annotations = map[string][]byte{annotationKey: []byte(kmipServerLayout)}
+	} else {
+		var aead cipher.AEAD
+		if aead, err = s.localKey(ctx, keyID); err == nil {
+			cipherText, err = sealAEAD(aead, keyID, plaintext)
+		}
+		annotations = aeadAnnotations()
+	}
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &service.EncryptResponse{
+		Ciphertext:  cipherText,
+		KeyID:       keyID,
+		Annotations: annotations,
+	}, nil
+}
+
+// Decrypt dispatches on the ciphertext layout, so that a ciphertext stays readable
+// when the mode changes, as long as the server allows the other mode.
+func (s *kmipRemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	if !strings.HasPrefix(req.KeyID, kmipPrefix) {
+		return nil, fmt.Errorf("/!\\ invalid keyID")
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "kmip.Decrypt")
+	defer span.End()
+
+	var plaintext []byte
+	var err error
+	if len(req.Annotations) == 1 && string(req.Annotations[annotationKey]) == kmipServerLayout {
+		plaintext, err = s.client.decrypt(ctx, strings.TrimPrefix(req.KeyID, kmipPrefix), req.Ciphertext, []byte(req.KeyID))
+	} else if err = checkAEADAnnotations(req.Annotations); err == nil {
+		var aead cipher.AEAD
+		if aead, err = s.localKey(ctx, req.KeyID); err == nil {
+			plaintext, err = openAEAD(aead, req.KeyID, req.Ciphertext)
+		}
+	}
+	tracing.RecordError(span, err)
+	return plaintext, err
+}
+
+// Status locates the key again, to follow the rotations, and reports it healthy
+// when its State is Active.
+func (s *kmipRemoteService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	healthz := healthOK
+	if err := s.locateKey(ctx); err != nil {
+		zap.L().Error("kmip: unable to locate the key", logger.Provider(kmipProvider), logger.Op("status"), zap.String("keyname", s.keyName), zap.Error(err))
+		healthz = healthNOK
+	}
+
+	s.mu.RLock()
+	keyID := s.current
+	s.mu.RUnlock()
+
+	if healthz == healthOK {
+		state, err := s.client.state(ctx, strings.TrimPrefix(keyID, kmipPrefix))
+		switch {
+		case err != nil:
+			zap.L().Error("kmip: unable to read the key state", logger.Provider(kmipProvider), logger.Op("status"), logger.KeyID(keyID), zap.Error(err))
+			healthz = healthNOK
+		case state != kmipStateActive:
+			healthz = fmt.Sprintf("key %s is %s", keyID, kmipStateName(state))
+		}
+	}
+
+	return &service.StatusResponse{
+		Version: "v2",
+		Healthz: healthz,
+		KeyID:   keyID,
+	}, nil
+}
+
+func kmipStateName(state uint32) string {
+	if name, ok := kmipStateNames[state]; ok {
+		return name
+	}
+	return fmt.Sprintf("state %#x", state)
+}
@@ -0,0 +1,472 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/kms/pkg/service"
+)
+
+// Test vectors of the KMIP 1.4 specification, section 9.1.2.
+func TestKMIPTTLV(t *testing.T) {
+	testCases := []struct {
+		name string
+		item kmipItem
+		hex  string
+	}{
+		{name: "Integer", item: kmipInt(0x420020, 8), hex: "42002002000000040000000800000000"},
+		{name: "Enumeration", item: kmipEnum(0x420020, 255), hex: "4200200500000004000000FF00000000"},
+		{name: "Boolean", item: kmipBool(0x420020, true), hex: "42002006000000080000000000000001"},
+		{name: "Text String", item: kmipText(0x420020, "Hello World"), hex: "420020070000000B48656C6C6F20576F726C640000000000"},
+		{name: "Byte String", item: kmipBytes(0x420020, []byte{1, 2, 3}), hex: "42002008000000030102030000000000"},
+		{name: "Structure", item: kmipStruct(0x420020, kmipEnum(0x420004, 254), kmipInt(0x420005, 255)),
+			hex: "42002001000000204200040500000004000000FE000000004200050200000004000000FF00000000"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := tc.item.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.ToUpper(hex.EncodeToString(data)); got != tc.hex {
+				t.Fatalf("marshal() = %s, want %s", got, tc.hex)
+			}
+			item, err := unmarshalKMIP(data)
+			if err != nil {
+				t.Fatalf("unmarshalKMIP() error: %v", err)
+			}
+			again, err := item.marshal()
+			if err != nil || !bytes.Equal(again, data) {
+				t.Fatalf("round trip = %X, %v", again, err)
+			}
+		})
+	}
+
+	if _, err := unmarshalKMIP([]byte{0x42, 0x00, 0x20, kmipTypeInteger, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Fatal("unmarshalKMIP() accepted an integer of 8 bytes")
+	}
+}
+
+// kmipStandIn is a KMIP server stand-in, in the spirit of the PyKMIP demo server,
+// serving the operations used by the kmip provider over mutual TLS.
+type kmipStandIn struct {
+	mu         sync.Mutex
+	operations []uint32
+	keys       map[string]*kmipStandInKey
+	// uids lists the keys, most recently created first like the Locate results.
+	uids []string
+	// stall holds the Encrypt responses until it is closed, when set.
+	stall chan struct{}
+}
+
+type kmipStandInKey struct {
+	name  string
+	state uint32
+	aead  cipher.AEAD
+	key   []byte
+}
+
+func (s *kmipStandIn) addKey(t *testing.T, name string) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uid := fmt.Sprintf("%d", len(s.uids)+1)
+	s.keys[uid] = &kmipStandInKey{name: name, state: kmipStateActive, aead: aead, key: key}
+	s.uids = append([]string{uid}, s.uids...)
+	return uid
+}
+
+func (s *kmipStandIn) setState(uid string, state uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[uid].state = state
+}
+
+func (s *kmipStandIn) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				request, err := readKMIP(conn)
+				if err != nil {
+					return
+				}
+				batchItem, _ := request.child(kmipTagBatchItem)
+				operation, _ := batchItem.enum(kmipTagOperation)
+				payload, _ := batchItem.child(kmipTagRequestPayload)
+
+				result := []kmipItem{kmipEnum(kmipTagOperation, operation)}
+				if response, err := s.handle(operation, payload); err != nil {
+					result = append(result,
+						kmipEnum(kmipTagResultStatus, 0x01),
+						kmipEnum(kmipTagResultReason, 0x01),
+						kmipText(kmipTagResultMessage, err.Error()))
+				} else {
+					result = append(result,
+						kmipEnum(kmipTagResultStatus, kmipResultStatusSuccess),
+						kmipStruct(kmipTagResponsePayload, response...))
+				}
+				s.mu.Lock()
+				stall := s.stall
+				s.mu.Unlock()
+				if operation == kmipOperationEncrypt && stall != nil {
+					<-stall
+				}
+				if err := writeKMIP(conn, kmipStruct(kmipTagResponseMessage,
+					kmipStruct(kmipTagResponseHeader,
+						kmipStruct(kmipTagProtocolVersion,
+							kmipInt(kmipTagProtocolVersionMajor, kmipProtocolVersionMajor),
+							kmipInt(kmipTagProtocolVersionMinor, kmipProtocolVersionMinor)),
+						kmipInt(kmipTagBatchCount, 1)),
+					kmipStruct(kmipTagBatchItem, result...))); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (s *kmipStandIn) handle(operation uint32, payload kmipItem) ([]kmipItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.operations, operation) {
+		return nil, errors.New("operation not supported")
+	}
+
+	uid := payload.text(kmipTagUniqueIdentifier)
+	key, ok := s.keys[uid]
+	if !ok && operation != kmipOperationQuery && operation != kmipOperationLocate {
+		return nil, errors.New("object not found")
+	}
+
+	switch operation {
+	case kmipOperationQuery:
+		var result []kmipItem
+		for _, op := range s.operations {
+			result = append(result, kmipEnum(kmipTagOperation, op))
+		}
+		return result, nil
+	case kmipOperationLocate:
+		var name string
+		state := uint32(0)
+		for _, attribute := range payload.children(kmipTagAttribute) {
+			switch attribute.text(kmipTagAttributeName) {
+			case "Name":
+				value, _ := attribute.child(kmipTagAttributeValue)
+				name = value.text(kmipTagNameValue)
+			case "State":
+				state, _ = attribute.enum(kmipTagAttributeValue)
+			}
+		}
+		var result []kmipItem
+		for _, uid := range s.uids {
+			if s.keys[uid].name == name && (state == 0 || s.keys[uid].state == state) {
+				result = append(result, kmipText(kmipTagUniqueIdentifier, uid))
+			}
+		}
+		return result, nil
+	case kmipOperationGetAttributes:
+		return []kmipItem{
+			kmipText(kmipTagUniqueIdentifier, uid),
+			kmipStruct(kmipTagAttribute,
+				kmipText(kmipTagAttributeName, "State"),
+				kmipEnum(kmipTagAttributeValue, key.state)),
+		}, nil
+	case kmipOperationGet:
+		return []kmipItem{
+			kmipEnum(kmipTagObjectType, kmipObjectTypeSymmetricKey),
+			kmipText(kmipTagUniqueIdentifier, uid),
+			kmipStruct(kmipTagSymmetricKey,
+				kmipStruct(kmipTagKeyBlock,
+					kmipEnum(kmipTagKeyFormatType, kmipKeyFormatTypeRaw),
+					kmipStruct(kmipTagKeyValue, kmipBytes(kmipTagKeyMaterial, key.key)),
+					kmipEnum(kmipTagCryptographicAlgorithm, kmipCryptographicAlgorithmAES),
+					kmipInt(kmipTagCryptographicLength, 256))),
+		}, nil
+	}
+
+	parameters, _ := payload.child(kmipTagCryptographicParameters)
+	if mode, _ := parameters.enum(kmipTagBlockCipherMode); mode != kmipBlockCipherModeGCM {
+		return nil, errors.New("block cipher mode not supported")
+	}
+	if key.state != kmipStateActive && operation == kmipOperationEncrypt {
+		return nil, errors.New("key not active")
+	}
+	ad := payload.bytes(kmipTagAuthenticatedEncryptionAD)
+	if operation == kmipOperationEncrypt {
+		nonce := make([]byte, kmipNonceSize)
+		rand.Read(nonce)
+		sealed := key.aead.Seal(nil, nonce, payload.bytes(kmipTagData), ad)
+		return []kmipItem{
+			kmipText(kmipTagUniqueIdentifier, uid),
+			kmipBytes(kmipTagData, sealed[:len(sealed)-kmipTagSize]),
+			kmipBytes(kmipTagIVCounterNonce, nonce),
+			kmipBytes(kmipTagAuthenticatedEncryptionTag, sealed[len(sealed)-kmipTagSize:]),
+		}, nil
+	}
+	plaintext, err := key.aead.Open(nil, payload.bytes(kmipTagIVCounterNonce),
+		append(payload.bytes(kmipTagData), payload.bytes(kmipTagAuthenticatedEncryptionTag)...), ad)
+	if err != nil {
+		return nil, err
+	}
+	return []kmipItem{kmipText(kmipTagUniqueIdentifier, uid), kmipBytes(kmipTagData, plaintext)}, nil
+}
+
+// writeKMIPCerts writes a CA, a server and a client certificate signed by the CA,
+// and returns the server TLS configuration.
+func writeKMIPCerts(t *testing.T, dir string) *tls.Config {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kleidi test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", caDER)
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) tls.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+		writePEM(t, filepath.Join(dir, name+"-key.pem"), "EC PRIVATE KEY", keyDER)
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	server := issue(2, "server", x509.ExtKeyUsageServerAuth)
+	issue(3, "client", x509.ExtKeyUsageClientAuth)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &tls.Config{
+		Certificates: []tls.Certificate{server},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startKMIPStandIn starts a stand-in server offering operations, and returns it
+// with its address and the directory of its certificates.
+func startKMIPStandIn(t *testing.T, operations ...uint32) (*kmipStandIn, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", writeKMIPCerts(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &kmipStandIn{operations: operations, keys: map[string]*kmipStandInKey{}}
+	go s.serve(ln)
+	return s, ln.Addr().String(), dir
+}
+
+// kmipTestConfig returns the configuration of a provider using the key named kleidi,
+// trusting the CA of caDir and authenticated by the client certificate of clientDir.
+func kmipTestConfig(address, caDir, clientDir string) ConfigSource {
+	return ConfigSource{Inline: []byte(fmt.Sprintf(`{"address": %q, "cacert": %q, "clientcert": %q, "clientkey": %q, "keyname": "kleidi"}`,
+		address, filepath.Join(caDir, "ca.pem"), filepath.Join(clientDir, "client.pem"), filepath.Join(clientDir, "client-key.pem")))}
+}
+
+func TestKMIPProvider(t *testing.T) {
+	allOperations := []uint32{kmipOperationQuery, kmipOperationLocate, kmipOperationGet,
+		kmipOperationGetAttributes, kmipOperationEncrypt, kmipOperationDecrypt}
+	testCases := []struct {
+		name       string
+		operations []uint32
+		mode       string
+		annotation string
+	}{
+		{name: "Server encryption", operations: allOperations, mode: kmipModeServer, annotation: kmipServerLayout},
+		{name: "Key retrieval only", operations: allOperations[:4], mode: kmipModeLocal, annotation: aeadLayoutVersion},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			standIn, address, dir := startKMIPStandIn(t, tc.operations...)
+			first := standIn.addKey(t, "kleidi")
+			standIn.addKey(t, "other")
+
+			svc, err := NewKMIPRemoteService(kmipTestConfig(address, dir, dir))
+			if err != nil {
+				t.Fatalf("NewKMIPRemoteService() error: %v", err)
+			}
+			defer Close(svc)
+			if mode := svc.(*kmipRemoteService).mode; mode != tc.mode {
+				t.Fatalf("mode = %s, want %s", mode, tc.mode)
+			}
+
+			status, err := svc.Status(ctx)
+			if err != nil || status.Healthz != healthOK || status.KeyID != kmipPrefix+first {
+				t.Fatalf("Status() = %v, %v", status, err)
+			}
+			before, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+			if err != nil {
+				t.Fatalf("Encrypt() error: %v", err)
+			}
+			if string(before.Annotations[annotationKey]) != tc.annotation {
+				t.Fatalf("annotations = %v, want the %s layout", before.Annotations, tc.annotation)
+			}
+
+			// rotating by creating a new key of the same name and deactivating the first one.
+			second := standIn.addKey(t, "kleidi")
+			standIn.setState(first, 0x03)
+			status, err = svc.Status(ctx)
+			if err != nil || status.Healthz != healthOK || status.KeyID != kmipPrefix+second {
+				t.Fatalf("Status() after rotation = %v, %v", status, err)
+			}
+			after, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+			if err != nil {
+				t.Fatalf("Encrypt() error: %v", err)
+			}
+
+			for _, resp := range []*service.EncryptResponse{before, after} {
+				plaintext, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{
+					Ciphertext:  resp.Ciphertext,
+					KeyID:       resp.KeyID,
+					Annotations: resp.Annotations,
+				})
+				if err != nil || string(plaintext) != "secret" {
+					t.Fatalf("Decrypt(%s) = %q, %v", resp.KeyID, plaintext, err)
+				}
+			}
+			// the KeyID is authenticated.
+			if _, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{
+				Ciphertext:  before.Ciphertext,
+				KeyID:       after.KeyID,
+				Annotations: before.Annotations,
+			}); err == nil {
+				t.Fatal("Decrypt() with another KeyID succeeded")
+			}
+
+			// the key is no longer active.
+			standIn.setState(second, 0x03)
+			if status, _ := svc.Status(ctx); status.Healthz == healthOK {
+				t.Fatalf("Status() with a deactivated key = %v", status)
+			}
+		})
+	}
+}
+
+func TestKMIPStalledResponse(t *testing.T) {
+	standIn, address, dir := startKMIPStandIn(t, kmipOperationQuery, kmipOperationLocate, kmipOperationGet,
+		kmipOperationGetAttributes, kmipOperationEncrypt, kmipOperationDecrypt)
+	standIn.addKey(t, "kleidi")
+	svc, err := NewKMIPRemoteService(kmipTestConfig(address, dir, dir))
+	if err != nil {
+		t.Fatalf("NewKMIPRemoteService() error: %v", err)
+	}
+	defer Close(svc)
+
+	// the server responds past the deadline of the first request.
+	stall := make(chan struct{})
+	standIn.mu.Lock()
+	standIn.stall = stall
+	standIn.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := svc.Encrypt(ctx, "uid", []byte("first")); err == nil {
+		t.Fatal("Encrypt() past the deadline succeeded")
+	}
+	close(stall)
+
+	// the next request reads its own response, not the stalled one.
+	resp, err := svc.Encrypt(context.Background(), "uid", []byte("second"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+	plaintext, err := svc.Decrypt(context.Background(), "uid", &service.DecryptRequest{
+		Ciphertext:  resp.Ciphertext,
+		KeyID:       resp.KeyID,
+		Annotations: resp.Annotations,
+	})
+	if err != nil || string(plaintext) != "second" {
+		t.Fatalf("Decrypt() = %q, %v, want %q", plaintext, err, "second")
+	}
+}
+
+func TestKMIPClientCertificate(t *testing.T) {
+	standIn, address, dir := startKMIPStandIn(t, kmipOperationQuery, kmipOperationLocate)
+	standIn.addKey(t, "kleidi")
+
+	// a client certificate of another CA is rejected by the server.
+	other := t.TempDir()
+	writeKMIPCerts(t, other)
+	if _, err := NewKMIPRemoteService(kmipTestConfig(address, dir, other)); err == nil {
+		t.Fatal("NewKMIPRemoteService() with an untrusted client certificate succeeded")
+	}
+}
+
+func TestValidateKMIPConfig(t *testing.T) {
+	cert := writeConfig(t, "")
+	testCases := []configTestCase{
+		{name: "Valid config", input: `{"address": "kmip.example.com:5696", "clientcert": "` + cert + `", "clientkey": "` + cert + `", "keyname": "kleidi"}`},
+		{name: "Missing port", input: `{"address": "kmip.example.com", "clientcert": "` + cert + `", "clientkey": "` + cert + `", "keyname": "kleidi"}`, expectErr: `must be host:port`},
+		{name: "Missing client certificate", input: `{"address": "kmip.example.com:5696", "keyname": "kleidi"}`, expectErr: `"clientcert" and "clientkey" are required`},
+		{name: "Missing key name", input: `{"address": "kmip.example.com:5696", "clientcert": "` + cert + `", "clientkey": "` + cert + `"}`, expectErr: `field "keyname" is required`},
+		{name: "Invalid mode", input: `{"address": "kmip.example.com:5696", "clientcert": "` + cert + `", "clientkey": "` + cert + `", "keyname": "kleidi", "mode": "wrap"}`, expectErr: `field "mode" set to "wrap"`},
+	}
+
+	testValidateConfig(t, "kmip", testCases)
+}
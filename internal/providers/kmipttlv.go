@@ -0,0 +1,291 @@
+package providers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A minimal KMIP TTLV codec, covering the items used by the kmip provider.
+// Each item is encoded as a 3 bytes tag, a 1 byte type, a 4 bytes length and
+// the value, padded with zeros to a multiple of 8 bytes.
+
+const (
+	kmipTypeStructure   byte = 0x01
+	kmipTypeInteger     byte = 0x02
+	kmipTypeLongInteger byte = 0x03
+	kmipTypeBigInteger  byte = 0x04
+	kmipTypeEnumeration byte = 0x05
+	kmipTypeBoolean     byte = 0x06
+	kmipTypeTextString  byte = 0x07
+	kmipTypeByteString  byte = 0x08
+	kmipTypeDateTime    byte = 0x09
+	kmipTypeInterval    byte = 0x0A
+
+	kmipHeaderSize = 8
+	// kmipMaxMessageSize bounds the messages read from the server.
+	kmipMaxMessageSize = 1 << 20
+)
+
+// The KMIP tags used by the kmip provider.
+const (
+	kmipTagAttribute                  uint32 = 0x420008
+	kmipTagAttributeName              uint32 = 0x42000A
+	kmipTagAttributeValue             uint32 = 0x42000B
+	kmipTagBatchCount                 uint32 = 0x42000D
+	kmipTagBatchItem                  uint32 = 0x42000F
+	kmipTagBlockCipherMode            uint32 = 0x420011
+	kmipTagCryptographicAlgorithm     uint32 = 0x420028
+	kmipTagCryptographicLength        uint32 = 0x42002A
+	kmipTagCryptographicParameters    uint32 = 0x42002B
+	kmipTagIVCounterNonce             uint32 = 0x42003D
+	kmipTagKeyBlock                   uint32 = 0x420040
+	kmipTagKeyFormatType              uint32 = 0x420042
+	kmipTagKeyMaterial                uint32 = 0x420043
+	kmipTagKeyValue                   uint32 = 0x420045
+	kmipTagMaximumItems               uint32 = 0x42004F
+	kmipTagName                       uint32 = 0x420053
+	kmipTagNameType                   uint32 = 0x420054
+	kmipTagNameValue                  uint32 = 0x420055
+	kmipTagObjectType                 uint32 = 0x420057
+	kmipTagOperation                  uint32 = 0x42005C
+	kmipTagProtocolVersion            uint32 = 0x420069
+	kmipTagProtocolVersionMajor       uint32 = 0x42006A
+	kmipTagProtocolVersionMinor       uint32 = 0x42006B
+	kmipTagQueryFunction              uint32 = 0x420074
+	kmipTagRequestHeader              uint32 = 0x420077
+	kmipTagRequestMessage             uint32 = 0x420078
+	kmipTagRequestPayload             uint32 = 0x420079
+	kmipTagResponseHeader             uint32 = 0x42007A
+	kmipTagResponseMessage            uint32 = 0x42007B
+	kmipTagResponsePayload            uint32 = 0x42007C
+	kmipTagResultMessage              uint32 = 0x42007D
+	kmipTagResultReason               uint32 = 0x42007E
+	kmipTagResultStatus               uint32 = 0x42007F
+	kmipTagState                      uint32 = 0x42008D
+	kmipTagSymmetricKey               uint32 = 0x42008F
+	kmipTagUniqueIdentifier           uint32 = 0x420094
+	kmipTagData                       uint32 = 0x4200C2
+	kmipTagRandomIV                   uint32 = 0x4200C5
+	kmipTagTagLength                  uint32 = 0x4200C7
+	kmipTagAuthenticatedEncryptionAD  uint32 = 0x4200FE
+	kmipTagAuthenticatedEncryptionTag uint32 = 0x4200FF
+)
+
+// kmipItem is a decoded TTLV item. Value holds []kmipItem for a structure, int32 for
+// an integer, int64 for a long integer or a date-time, uint32 for an enumeration or
+// an interval, bool, string, and []byte for a byte string or a big integer.
+type kmipItem struct {
+	Tag   uint32
+	Type  byte
+	Value any
+}
+
+func kmipStruct(tag uint32, items ...kmipItem) kmipItem {
+	return kmipItem{Tag: tag, Type: kmipTypeStructure, Value: items}
+}
+
+func kmipInt(tag uint32, v int32) kmipItem {
+	return kmipItem{Tag: tag, Type: kmipTypeInteger, Value: v}
+}
+
+func kmipEnum(tag uint32, v uint32) kmipItem {
+	return kmipItem{Tag: tag, Type: kmipTypeEnumeration, Value: v}
+}
+
+func kmipBool(tag uint32, v bool) kmipItem {
+	return kmipItem{Tag: tag, Type: kmipTypeBoolean, Value: v}
+}
+
+func kmipText(tag uint32, v string) kmipItem {
+	return kmipItem{Tag: tag, Type: kmipTypeTextString, Value: v}
+}
+
+func kmipBytes(tag uint32, v []byte) kmipItem {
+	return kmipItem{Tag: tag, Type: kmipTypeByteString, Value: v}
+}
+
+// child returns the first item tagged tag of a structure.
+func (i kmipItem) child(tag uint32) (kmipItem, bool) {
+	items, _ := i.Value.([]kmipItem)
+	for _, item := range items {
+		if item.Tag == tag {
+			return item, true
+		}
+	}
+	return kmipItem{}, false
+}
+
+// children returns the items tagged tag of a structure.
+func (i kmipItem) children(tag uint32) []kmipItem {
+	var result []kmipItem
+	items, _ := i.Value.([]kmipItem)
+	for _, item := range items {
+		if item.Tag == tag {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// text returns the text string tagged tag of a structure, or "".
+func (i kmipItem) text(tag uint32) string {
+	item, _ := i.child(tag)
+	v, _ := item.Value.(string)
+	return v
+}
+
+// bytes returns the byte string tagged tag of a structure, or nil.
+func (i kmipItem) bytes(tag uint32) []byte {
+	item, _ := i.child(tag)
+	v, _ := item.Value.([]byte)
+	return v
+}
+
+// enum returns the enumeration tagged tag of a structure, and whether it is present.
+func (i kmipItem) enum(tag uint32) (uint32, bool) {
+	item, _ := i.child(tag)
+	v, ok := item.Value.(uint32)
+	return v, ok && item.Type == kmipTypeEnumeration
+}
+
+// marshal encodes the item in TTLV.
+func (i kmipItem) marshal() ([]byte, error) {
+	var value []byte
+	switch v := i.Value.(type) {
+	case []kmipItem:
+		for _, item := range v {
+			data, err := item.marshal()
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, data...)
+		}
+	case int32:
+		value = binary.BigEndian.AppendUint32(nil, uint32(v))
+	case int64:
+		value = binary.BigEndian.AppendUint64(nil, uint64(v))
+	case uint32:
+		value = binary.BigEndian.AppendUint32(nil, v)
+	case bool:
+		value = make([]byte, 8)
+		if v {
+			value[7] = 1
+		}
+	case string:
+		value = []byte(v)
+	case []byte:
+		value = v
+	default:
+		return nil, fmt.Errorf("unsupported value %T of tag %06X", i.Value, i.Tag)
+	}
+
+	data := make([]byte, kmipHeaderSize, kmipHeaderSize+len(value)+7)
+	data[0], data[1], data[2] = byte(i.Tag>>16), byte(i.Tag>>8), byte(i.Tag)
+	data[3] = i.Type
+	binary.BigEndian.PutUint32(data[4:], uint32(len(value)))
+	data = append(data, value...)
+	if pad := len(value) % 8; pad != 0 {
+		data = append(data, make([]byte, 8-pad)...)
+	}
+	return data, nil
+}
+
+// unmarshalKMIP decodes a single TTLV item filling data.
+func unmarshalKMIP(data []byte) (kmipItem, error) {
+	item, rest, err := decodeKMIP(data)
+	if err != nil {
+		return kmipItem{}, err
+	}
+	if len(rest) != 0 {
+		return kmipItem{}, fmt.Errorf("%d trailing bytes after the TTLV item", len(rest))
+	}
+	return item, nil
+}
+
+func decodeKMIP(data []byte) (kmipItem, []byte, error) {
+	if len(data) < kmipHeaderSize {
+		return kmipItem{}, nil, fmt.Errorf("truncated TTLV header")
+	}
+	item := kmipItem{
+		Tag:  uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2]),
+		Type: data[3],
+	}
+	length := int(binary.BigEndian.Uint32(data[4:]))
+	padded := length
+	if pad := length % 8; pad != 0 {
+		padded += 8 - pad
+	}
+	if len(data)-kmipHeaderSize < padded {
+		return kmipItem{}, nil, fmt.Errorf("truncated TTLV value of tag %06X", item.Tag)
+	}
+	value, rest := data[kmipHeaderSize:kmipHeaderSize+length], data[kmipHeaderSize+padded:]
+
+	fixed := map[byte]int{
+		kmipTypeInteger: 4, kmipTypeLongInteger: 8, kmipTypeEnumeration: 4,
+		kmipTypeBoolean: 8, kmipTypeDateTime: 8, kmipTypeInterval: 4,
+	}
+	if size, ok := fixed[item.Type]; ok && length != size {
+		return kmipItem{}, nil, fmt.Errorf("invalid length %d of tag %06X", length, item.Tag)
+	}
+
+	switch item.Type {
+	case kmipTypeStructure:
+		items := []kmipItem{}
+		for len(value) != 0 {
+			child, next, err := decodeKMIP(value)
+			if err != nil {
+				return kmipItem{}, nil, err
+			}
+			items = append(items, child)
+			value = next
+		}
+		item.Value = items
+	case kmipTypeInteger:
+		item.Value = int32(binary.BigEndian.Uint32(value))
+	case kmipTypeLongInteger, kmipTypeDateTime:
+		item.Value = int64(binary.BigEndian.Uint64(value))
+	case kmipTypeEnumeration, kmipTypeInterval:
+		item.Value = binary.BigEndian.Uint32(value)
+	case kmipTypeBoolean:
+		item.Value = binary.BigEndian.Uint64(value) != 0
+	case kmipTypeTextString:
+		item.Value = string(value)
+	case kmipTypeByteString, kmipTypeBigInteger:
+		item.Value = append([]byte(nil), value...)
+	default:
+		return kmipItem{}, nil, fmt.Errorf("unsupported type %#x of tag %06X", item.Type, item.Tag)
+	}
+	return item, rest, nil
+}
+
+// readKMIP reads a TTLV message from r.
+func readKMIP(r io.Reader) (kmipItem, error) {
+	header := make([]byte, kmipHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return kmipItem{}, err
+	}
+	if header[3] != kmipTypeStructure {
+		return kmipItem{}, fmt.Errorf("message of type %#x, expecting a structure", header[3])
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if length > kmipMaxMessageSize || length%8 != 0 {
+		return kmipItem{}, fmt.Errorf("invalid message length %d", length)
+	}
+	data := make([]byte, kmipHeaderSize+int(length))
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[kmipHeaderSize:]); err != nil {
+		return kmipItem{}, err
+	}
+	return unmarshalKMIP(data)
+}
+
+// writeKMIP writes item to w.
+func writeKMIP(w io.Writer, item kmipItem) error {
+	data, err := item.marshal()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
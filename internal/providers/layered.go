@@ -0,0 +1,166 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	layeredProvider = "layered"
+	// layeredAnnotationKey holds the KeyID of the outermost layer.
+	layeredAnnotationKey = "layered.kleidi.beezy.dev"
+	// layeredKeyIDSeparator joins the KeyIDs of the layers, from the innermost.
+	layeredKeyIDSeparator = "+"
+)
+
+var _ service.Service = &layeredRemoteService{}
+
+// layeredConfig is the layered provider configuration.
+type layeredConfig struct {
+	// Layers are the wrapping providers, from the innermost, which wraps the data key.
+	Layers []memberConfig `json:"layers"`
+}
+
+func (c *layeredConfig) validate() error {
+	if len(c.Layers) < 2 {
+		return errors.New("field \"layers\" requires at least 2 layers")
+	}
+	return validateMembers(c.Layers)
+}
+
+func readLayeredConfig(source ConfigSource) (*layeredConfig, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read layered config: %v", err)
+	}
+	config := &layeredConfig{}
+	if err := decodeStrict(data, config, true); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid layered config %s: %v", source, err)
+	}
+	if err := checkMembersKeys(data, "layers"); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid layered config %s: %v", source, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid layered config %s: %v", source, err)
+	}
+	return config, nil
+}
+
+// layeredRemoteService wraps the data keys with each layer in turn, so that the
+// custodians of all the layers are needed to decrypt them. The output of a layer,
+// its KeyID, annotations and ciphertext, is the plaintext of the next layer.
+type layeredRemoteService struct {
+	layers []member
+}
+
+// NewLayeredRemoteService creates a layered remote service, building each layer.
+func NewLayeredRemoteService(source ConfigSource) (service.Service, error) {
+	config, err := readLayeredConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := newMembers(config.Layers)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(layers))
+	for i, l := range layers {
+		names[i] = l.name
+	}
+	zap.L().Info("INFO: layered provider ready", logger.Provider(layeredProvider), zap.Strings("layers", names))
+	return &layeredRemoteService{layers: layers}, nil
+}
+
+// Close closes every layer.
+func (s *layeredRemoteService) Close() error {
+	return closeMembers(s.layers)
+}
+
+// Encrypt wraps plaintext with the layers, from the innermost. The KeyID joins the
+// KeyIDs of the layers, and the annotations of the outermost layer carry its KeyID.
+func (s *layeredRemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	keyIDs := make([]string, len(s.layers))
+	var resp *service.EncryptResponse
+	for i, l := range s.layers {
+		if i > 0 {
+			plaintext = marshalEnvelope(resp)
+		}
+		var err error
+		resp, err = l.service.Encrypt(ctx, uid, plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("/!\\ layer %s: %v", l.name, err)
+		}
+		keyIDs[i] = resp.KeyID
+	}
+
+	annotations := maps.Clone(resp.Annotations)
+	if annotations == nil {
+		annotations = map[string][]byte{}
+	}
+	annotations[layeredAnnotationKey] = []byte(resp.KeyID)
+	return &service.EncryptResponse{
+		Ciphertext:  resp.Ciphertext,
+		KeyID:       strings.Join(keyIDs, layeredKeyIDSeparator),
+		Annotations: annotations,
+	}, nil
+}
+
+// Decrypt unwraps the ciphertext with the layers, from the outermost, and checks
+// the KeyID against the KeyIDs of the layers.
+func (s *layeredRemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	outerKeyID, ok := req.Annotations[layeredAnnotationKey]
+	if !ok {
+		return nil, fmt.Errorf("/!\\ ciphertext without %s annotation", layeredAnnotationKey)
+	}
+	annotations := maps.Clone(req.Annotations)
+	delete(annotations, layeredAnnotationKey)
+	layerReq := &service.DecryptRequest{
+		Ciphertext:  req.Ciphertext,
+		KeyID:       string(outerKeyID),
+		Annotations: annotations,
+	}
+
+	keyIDs := make([]string, len(s.layers))
+	var plaintext []byte
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		l := s.layers[i]
+		keyIDs[i] = layerReq.KeyID
+		var err error
+		plaintext, err = l.service.Decrypt(ctx, uid, layerReq)
+		if err != nil {
+			return nil, fmt.Errorf("/!\\ layer %s: %v", l.name, err)
+		}
+		if i > 0 {
+			if layerReq, err = unmarshalEnvelope(plaintext); err != nil {
+				return nil, fmt.Errorf("/!\\ layer %s: %v", l.name, err)
+			}
+		}
+	}
+
+	if strings.Join(keyIDs, layeredKeyIDSeparator) != req.KeyID {
+		return nil, fmt.Errorf("/!\\ invalid keyID")
+	}
+	return plaintext, nil
+}
+
+// Status joins the KeyIDs of the layers, and is healthy only when every layer is.
+func (s *layeredRemoteService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	statuses, healthz := membersStatus(ctx, s.layers)
+	keyIDs := make([]string, len(statuses))
+	for i, status := range statuses {
+		keyIDs[i] = status.KeyID
+	}
+	return &service.StatusResponse{
+		Version: "v2",
+		Healthz: healthz,
+		KeyID:   strings.Join(keyIDs, layeredKeyIDSeparator),
+	}, nil
+}
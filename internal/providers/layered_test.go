@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/kms/pkg/service"
+)
+
+func TestLayeredProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	svc, err := NewLayeredRemoteService(ConfigSource{Inline: []byte(fmt.Sprintf(`{"layers": [%s, %s]}`,
+		localkeyMember(dir, "hsm"), localkeyMember(dir, "vault")))})
+	if err != nil {
+		t.Fatalf("NewLayeredRemoteService() error: %v", err)
+	}
+	defer Close(svc)
+
+	status, err := svc.Status(ctx)
+	if err != nil || status.Healthz != healthOK {
+		t.Fatalf("Status() = %v, %v", status, err)
+	}
+	resp, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+	if resp.KeyID != status.KeyID || !strings.Contains(resp.KeyID, layeredKeyIDSeparator) {
+		t.Fatalf("Encrypt() KeyID = %q, want %q", resp.KeyID, status.KeyID)
+	}
+	req := &service.DecryptRequest{Ciphertext: resp.Ciphertext, KeyID: resp.KeyID, Annotations: resp.Annotations}
+	plaintext, err := svc.Decrypt(ctx, "uid", req)
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("Decrypt() = %q, %v", plaintext, err)
+	}
+
+	// the KeyID of the inner layer is authenticated by the outer layer.
+	keyID := "localkey-other" + layeredKeyIDSeparator + string(resp.Annotations[layeredAnnotationKey])
+	if _, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: resp.Ciphertext, KeyID: keyID, Annotations: resp.Annotations}); err == nil {
+		t.Fatal("Decrypt() with another KeyID succeeded")
+	}
+
+	// the inner layer alone can not decrypt.
+	inner, err := NewLocalkeyRemoteService(ConfigSource{Inline: []byte(`{"keydir": "` + filepath.Join(dir, "hsm") + `"}`)})
+	if err != nil {
+		t.Fatalf("NewLocalkeyRemoteService() error: %v", err)
+	}
+	if _, err := inner.Decrypt(ctx, "uid", req); err == nil {
+		t.Fatal("Decrypt() by the inner layer alone succeeded")
+	}
+
+	// an unavailable layer makes the provider unhealthy.
+	if err := os.WriteFile(filepath.Join(dir, "vault", "zz-invalid.key"), []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := svc.Status(ctx); !strings.HasPrefix(status.Healthz, "member vault: ") {
+		t.Fatalf("Status() without the outer layer = %v", status)
+	}
+}
+
+func TestValidateLayeredConfig(t *testing.T) {
+	dir := t.TempDir()
+	hsm, vault := localkeyMember(dir, "hsm"), localkeyMember(dir, "vault")
+	testCases := []configTestCase{
+		{name: "Valid config", input: `{"layers": [` + hsm + `, ` + vault + `]}`},
+		{name: "Single layer", input: `{"layers": [` + hsm + `]}`, expectErr: `requires at least 2 layers`},
+		{name: "Duplicate layer", input: `{"layers": [` + hsm + `, ` + hsm + `]}`, expectErr: `member name "hsm" is not unique`},
+		{name: "Nested layered", input: `{"layers": [` + hsm + `, {"name": "inner", "provider": "layered", "config": {}}]}`, expectErr: `field "provider" set to "layered" is not supported`},
+		{name: "Typo in layer field name", input: `{"layers": [` + hsm + `, {"name": "vault", "provider": "localkey", "configFile": "/tmp/x"}]}`, expectErr: `member 1: unknown field "configFile", did you mean "configfile"?`},
+	}
+
+	testValidateConfig(t, "layered", testCases)
+}
@@ -0,0 +1,246 @@
+package providers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"github.com/beezy-dev/kleidi/internal/tracing"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	localkeyProvider = "localkey"
+	localkeyPrefix   = "localkey-"
+	localkeySuffix   = ".key"
+	localkeySize     = 32
+)
+
+var _ service.Service = &localkeyRemoteService{}
+
+// localkeyNameRegexp restricts the key file names, which are part of the KeyID.
+var localkeyNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// localkeyConfig is the localkey provider configuration.
+type localkeyConfig struct {
+	// KeyDir holds the AES-256 keys, one per <name>.key file, raw or base64 encoded.
+	// The key with the last name in lexical order encrypts.
+	KeyDir string `json:"keydir"`
+	// Generate creates a first key when KeyDir holds none.
+	Generate bool `json:"generate"`
+}
+
+// localkeyRemoteService encrypts with AES-256-GCM keys read from files, using the
+// ciphertext layout of the PKCS#11 provider. The keys are stored in clear on disk:
+// it is meant for development and CI, not for production.
+type localkeyRemoteService struct {
+	keyDir string
+
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+func readLocalkeyConfig(source ConfigSource) (*localkeyConfig, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read localkey config: %v", err)
+	}
+	config := &localkeyConfig{}
+	if err := decodeStrict(data, config, true); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid localkey config %s: %v", source, err)
+	}
+	if len(config.KeyDir) == 0 {
+		return nil, fmt.Errorf("/!\\ invalid localkey config %s: field \"keydir\" is required", source)
+	}
+	return config, nil
+}
+
+// NewLocalkeyRemoteService creates a localkey remote service from the keys of the configured directory.
+func NewLocalkeyRemoteService(source ConfigSource) (service.Service, error) {
+	config, err := readLocalkeyConfig(source)
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Warn("NON-PRODUCTION: the localkey provider stores the keys in clear on disk, use it for development and CI only",
+		logger.Provider(localkeyProvider), zap.String("keydir", config.KeyDir))
+
+	s := &localkeyRemoteService{keyDir: config.KeyDir}
+	if err := s.loadKeys(); err != nil {
+		return nil, err
+	}
+	if len(s.keys) == 0 {
+		if !config.Generate {
+			return nil, fmt.Errorf("/!\\ no %s file in %s, add one or set \"generate\"", localkeySuffix, config.KeyDir)
+		}
+		if err := generateLocalkey(config.KeyDir); err != nil {
+			return nil, err
+		}
+		if err := s.loadKeys(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// generateLocalkey writes a random key named after the current time, so that the
+// keys added later for a rotation sort after it.
+func generateLocalkey(keyDir string) error {
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		return fmt.Errorf("/!\\ unable to create the key directory: %v", err)
+	}
+	key := make([]byte, localkeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	name := time.Now().UTC().Format("20060102T150405Z") + localkeySuffix
+	path := filepath.Join(keyDir, name)
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("/!\\ unable to write the generated key: %v", err)
+	}
+	zap.L().Info("INFO: localkey generated", logger.Provider(localkeyProvider), zap.String("file", path))
+	return nil
+}
+
+// loadKeys reads the key files of the key directory. The keys already loaded are
+// kept, so that the data encrypted with a removed key file can still be decrypted
+// until the next restart.
+func (s *localkeyRemoteService) loadKeys() error {
+	files, err := filepath.Glob(filepath.Join(s.keyDir, "*"+localkeySuffix))
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]cipher.AEAD)
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), localkeySuffix)
+		if !localkeyNameRegexp.MatchString(name) {
+			return fmt.Errorf("/!\\ invalid key file name %s, expecting [A-Za-z0-9_.-]+%s", file, localkeySuffix)
+		}
+		aead, err := readLocalkey(file)
+		if err != nil {
+			return fmt.Errorf("/!\\ invalid key file %s: %v", file, err)
+		}
+		keys[localkeyPrefix+name] = aead
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string]cipher.AEAD)
+	}
+	for keyID, aead := range keys {
+		s.keys[keyID] = aead
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	current := ""
+	for keyID := range keys {
+		current = max(current, keyID)
+	}
+	if current != s.current {
+		if len(s.current) != 0 {
+			zap.L().Info("INFO: localkey rotated", logger.Provider(localkeyProvider), zap.String("from", s.current), zap.String("to", current))
+		}
+		s.current = current
+	}
+	return nil
+}
+
+// readLocalkey reads an AES-256 key, raw or base64 encoded, and returns its GCM AEAD.
+func readLocalkey(file string) (cipher.AEAD, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		zap.L().Warn("localkey file readable by other users", logger.Provider(localkeyProvider),
+			zap.String("file", file), zap.Stringer("mode", info.Mode().Perm()))
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key := data
+	if len(key) != localkeySize {
+		if key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err != nil || len(key) != localkeySize {
+			return nil, fmt.Errorf("expecting %d bytes, raw or base64 encoded", localkeySize)
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *localkeyRemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	s.mu.RLock()
+	keyID, aead := s.current, s.keys[s.current]
+	s.mu.RUnlock()
+
+	_, span := tracing.Tracer().Start(ctx, "localkey.Seal")
+	cipherText, err := sealAEAD(aead, keyID, plaintext)
+	span.End()
+	if err != nil {
+		return nil, err
+	}
+
+	return &service.EncryptResponse{
+		Ciphertext:  cipherText,
+		KeyID:       keyID,
+		Annotations: aeadAnnotations(),
+	}, nil
+}
+
+func (s *localkeyRemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	if err := checkAEADAnnotations(req.Annotations); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	aead, ok := s.keys[req.KeyID]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("/!\\ invalid keyID")
+	}
+
+	_, span := tracing.Tracer().Start(ctx, "localkey.Open")
+	defer span.End()
+	plaintext, err := openAEAD(aead, req.KeyID, req.Ciphertext)
+	tracing.RecordError(span, err)
+	return plaintext, err
+}
+
+// Status reloads the key directory, so that adding a key file rotates the key
+// reported to the API server.
+func (s *localkeyRemoteService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	healthz := healthOK
+	if err := s.loadKeys(); err != nil {
+		zap.L().Error("localkey: unable to reload the keys", logger.Provider(localkeyProvider), zap.Error(err))
+		healthz = healthNOK
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &service.StatusResponse{
+		Version: "v2",
+		Healthz: healthz,
+		KeyID:   s.current,
+	}, nil
+}
@@ -0,0 +1,72 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/kms/pkg/service"
+)
+
+func TestLocalkeyRotation(t *testing.T) {
+	ctx := context.Background()
+	keyDir := filepath.Join(t.TempDir(), "keys")
+	source := ConfigSource{Inline: []byte(`{"keydir": "` + keyDir + `", "generate": true}`)}
+
+	svc, err := NewLocalkeyRemoteService(source)
+	if err != nil {
+		t.Fatalf("NewLocalkeyRemoteService() error: %v", err)
+	}
+	first, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+
+	// rotating by adding a key file sorting after the generated one.
+	if err := os.WriteFile(filepath.Join(keyDir, "zz-rotated.key"), bytes.Repeat([]byte{1}, localkeySize), 0600); err != nil {
+		t.Fatal(err)
+	}
+	status, err := svc.Status(ctx)
+	if err != nil || status.Healthz != healthOK {
+		t.Fatalf("Status() = %v, %v", status, err)
+	}
+	if status.KeyID != "localkey-zz-rotated" {
+		t.Fatalf("KeyID after rotation = %q, want localkey-zz-rotated", status.KeyID)
+	}
+
+	second, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+	for _, resp := range []*service.EncryptResponse{first, second} {
+		plaintext, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{
+			Ciphertext:  resp.Ciphertext,
+			KeyID:       resp.KeyID,
+			Annotations: resp.Annotations,
+		})
+		if err != nil || string(plaintext) != "secret" {
+			t.Fatalf("Decrypt(%s) = %q, %v", resp.KeyID, plaintext, err)
+		}
+	}
+
+	// the KeyID is authenticated.
+	if _, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{
+		Ciphertext:  first.Ciphertext,
+		KeyID:       second.KeyID,
+		Annotations: first.Annotations,
+	}); err == nil {
+		t.Fatal("Decrypt() with another KeyID succeeded")
+	}
+}
+
+func TestValidateLocalkeyConfig(t *testing.T) {
+	testCases := []configTestCase{
+		{name: "Valid config", input: `{"keydir": "/opt/kleidi/keys"}`},
+		{name: "Missing keydir", input: `{"generate": true}`, expectErr: `field "keydir" is required`},
+		{name: "Typo in field name", input: `{"keyDir": "/opt/kleidi/keys"}`, expectErr: `did you mean "keydir"?`},
+	}
+
+	testValidateConfig(t, "localkey", testCases)
+}
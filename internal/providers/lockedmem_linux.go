@@ -0,0 +1,35 @@
+package providers
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocLocked maps size bytes of anonymous memory, locked in RAM so that they are
+// never swapped, and excluded from the core dumps.
+func allocLocked(size int) ([]byte, error) {
+	mem, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ unable to map memory: %v", err)
+	}
+	if err := unix.Mlock(mem); err != nil {
+		unix.Munmap(mem)
+		return nil, fmt.Errorf("/!\\ unable to lock memory, check RLIMIT_MEMLOCK or CAP_IPC_LOCK: %v", err)
+	}
+	if err := unix.Madvise(mem, unix.MADV_DONTDUMP); err != nil {
+		unix.Munlock(mem)
+		unix.Munmap(mem)
+		return nil, fmt.Errorf("/!\\ unable to exclude memory from core dumps: %v", err)
+	}
+	return mem, nil
+}
+
+// freeLocked zeroes and releases the memory of allocLocked.
+func freeLocked(mem []byte) error {
+	clear(mem)
+	if err := unix.Munlock(mem); err != nil {
+		return err
+	}
+	return unix.Munmap(mem)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package providers
+
+import "errors"
+
+// allocLocked is only supported on Linux, where the memory is locked with mlock.
+func allocLocked(size int) ([]byte, error) {
+	return nil, errors.New("/!\\ locked memory is only supported on linux")
+}
+
+func freeLocked(mem []byte) error {
+	clear(mem)
+	return nil
+}
@@ -0,0 +1,123 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const openbaoProvider = "openbao"
+
+// openbaoNamespacesVersion is the first OpenBao release supporting namespaces.
+var openbaoNamespacesVersion = [2]int{2, 3}
+
+// NewOpenBaoRemoteService creates an OpenBao remote service. OpenBao is API compatible
+// with HashiCorp Vault and shares the hvault configuration schema; the divergences
+// are handled here:
+//   - the client TLS settings are read from the BAO_* environment variables, in
+//     addition to the VAULT_* ones of the Vault client;
+//   - the namespaces require OpenBao 2.3 or later, and are refused on the older
+//     releases instead of being ignored.
+func NewOpenBaoRemoteService(source ConfigSource) (service.Service, error) {
+	baoService, err := readConfig(openbaoProvider, source)
+	if err != nil {
+		return nil, err
+	}
+	baoconfig, err := openbaoConfig(baoService.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := baoService.checkOpenBaoServer(baoconfig); err != nil {
+		return nil, err
+	}
+	if err := baoService.connect(baoconfig); err != nil {
+		return nil, err
+	}
+	return baoService, nil
+}
+
+// openbaoConfig returns the client configuration of address, with the TLS settings
+// of the BAO_* environment variables when set.
+func openbaoConfig(address string) (*api.Config, error) {
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return nil, fmt.Errorf("/!\\ invalid VAULT_* environment: %v", config.Error)
+	}
+	config.Address = address
+
+	tls := &api.TLSConfig{
+		CACert:        os.Getenv("BAO_CACERT"),
+		CAPath:        os.Getenv("BAO_CAPATH"),
+		ClientCert:    os.Getenv("BAO_CLIENT_CERT"),
+		ClientKey:     os.Getenv("BAO_CLIENT_KEY"),
+		TLSServerName: os.Getenv("BAO_TLS_SERVER_NAME"),
+	}
+	if v, ok := os.LookupEnv("BAO_SKIP_VERIFY"); ok {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("/!\\ invalid BAO_SKIP_VERIFY %q: %v", v, err)
+		}
+		tls.Insecure = insecure
+	}
+	if len(tls.CACert)+len(tls.CAPath)+len(tls.ClientCert)+len(tls.ClientKey)+len(tls.TLSServerName) == 0 && !tls.Insecure {
+		return config, nil
+	}
+	if err := config.ConfigureTLS(tls); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid BAO_* TLS environment: %v", err)
+	}
+	return config, nil
+}
+
+// checkOpenBaoServer reads the server version from the unauthenticated seal status,
+// and refuses a namespace on the OpenBao releases without namespaces.
+func (s *hvaultRemoteService) checkOpenBaoServer(config *api.Config) error {
+	client, err := api.NewClient(config)
+	if err != nil {
+		return fmt.Errorf("/!\\ failed to initialize OpenBao client: %v", err)
+	}
+	sealStatus, err := client.Sys().SealStatusWithContext(context.Background())
+	if err != nil {
+		return fmt.Errorf("/!\\ unable to read the OpenBao seal status: %v", err)
+	}
+	s.logger().Info("INFO: OpenBao server", zap.String("version", sealStatus.Version), zap.Bool("sealed", sealStatus.Sealed))
+
+	version, ok := parseMajorMinor(sealStatus.Version)
+	if !ok {
+		s.logger().Warn("unable to parse the OpenBao server version", zap.String("version", sealStatus.Version))
+		return nil
+	}
+	// HashiCorp Vault releases are 1.x, OpenBao releases start at 2.0.
+	if version[0] < 2 {
+		s.logger().Warn("the server looks like HashiCorp Vault, consider the hvault provider", zap.String("version", sealStatus.Version))
+		return nil
+	}
+	if len(s.Namespace) != 0 && (version[0] < openbaoNamespacesVersion[0] ||
+		version[0] == openbaoNamespacesVersion[0] && version[1] < openbaoNamespacesVersion[1]) {
+		return fmt.Errorf("/!\\ namespace %q requires OpenBao %d.%d or later, the server runs %s",
+			s.Namespace, openbaoNamespacesVersion[0], openbaoNamespacesVersion[1], sealStatus.Version)
+	}
+	return nil
+}
+
+// parseMajorMinor parses the major and minor numbers of a version like "2.3.1" or "v2.0.0-beta".
+func parseMajorMinor(version string) ([2]int, bool) {
+	var majorMinor [2]int
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return majorMinor, false
+	}
+	for i := range majorMinor {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return majorMinor, false
+		}
+		majorMinor[i] = n
+	}
+	return majorMinor, true
+}
@@ -0,0 +1,214 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"k8s.io/kms/pkg/service"
+)
+
+// Test cases for the OpenBao server checks against a fake seal status endpoint.
+func TestCheckOpenBaoServer(t *testing.T) {
+	testCases := []struct {
+		name      string
+		version   string
+		namespace string
+		expectErr string
+	}{
+		{name: "Without namespace", version: "2.0.0"},
+		{name: "Namespace supported", version: "2.3.1", namespace: "team-a"},
+		{name: "Namespace not supported", version: "2.2.0", namespace: "team-a", expectErr: "requires OpenBao 2.3 or later"},
+		{name: "HashiCorp Vault server", version: "1.17.2", namespace: "team-a"},
+		{name: "Unparsable version", version: "dev", namespace: "team-a"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/sys/seal-status" {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprintf(w, `{"type": "shamir", "sealed": false, "version": %q}`, tc.version)
+			}))
+			defer server.Close()
+
+			config, err := openbaoConfig(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &hvaultRemoteService{provider: openbaoProvider, Namespace: tc.namespace}
+			err = s.checkOpenBaoServer(config)
+			if len(tc.expectErr) == 0 && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tc.expectErr) != 0 && (err == nil || !strings.Contains(err.Error(), tc.expectErr)) {
+				t.Fatalf("expected error containing %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+// newOpenBaoDevClient returns a client of the OpenBao dev server set by KLEIDI_OPENBAO_ADDR
+// and KLEIDI_OPENBAO_TOKEN, e.g. started with `bao server -dev -dev-root-token-id=root`.
+func newOpenBaoDevClient(t *testing.T) *api.Client {
+	t.Helper()
+	addr, token := os.Getenv("KLEIDI_OPENBAO_ADDR"), os.Getenv("KLEIDI_OPENBAO_TOKEN")
+	if len(addr) == 0 || len(token) == 0 {
+		t.Skip("KLEIDI_OPENBAO_ADDR and KLEIDI_OPENBAO_TOKEN not set, skipping the OpenBao compatibility tests")
+	}
+	config, err := openbaoConfig(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(token)
+	return client
+}
+
+// newOpenBaoDevService mounts a transit engine with a key in the current namespace of
+// root, and returns a provider using a renewable child token of root.
+func newOpenBaoDevService(t *testing.T, root *api.Client) *hvaultRemoteService {
+	t.Helper()
+	transitPath := fmt.Sprintf("kleidi-transit-%d", time.Now().UnixNano())
+	if err := root.Sys().Mount(transitPath, &api.MountInput{Type: "transit"}); err != nil {
+		t.Fatalf("mount transit: %v", err)
+	}
+	t.Cleanup(func() { root.Sys().Unmount(transitPath) })
+	if _, err := root.Logical().Write(transitPath+"/keys/kleidi", nil); err != nil {
+		t.Fatalf("create transit key: %v", err)
+	}
+
+	secret, err := root.Auth().Token().Create(&api.TokenCreateRequest{TTL: "1h", Policies: []string{"root"}})
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	client, err := root.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(secret.Auth.ClientToken)
+	client.SetNamespace(root.Namespace())
+
+	return &hvaultRemoteService{
+		Client:      client,
+		provider:    openbaoProvider,
+		Namespace:   root.Namespace(),
+		Transitkey:  "kleidi",
+		TransitPath: transitPath,
+	}
+}
+
+func TestOpenBaoCompatibility(t *testing.T) {
+	root := newOpenBaoDevClient(t)
+	s := newOpenBaoDevService(t, root)
+	ctx := context.Background()
+
+	t.Run("Key read", func(t *testing.T) {
+		key, err := s.GetTransitKey(ctx)
+		if err != nil {
+			t.Fatalf("GetTransitKey() error: %v", err)
+		}
+		if latest := createLatestTransitKeyId(key); !strings.HasPrefix(latest, keyID+"_1_") {
+			t.Fatalf("KeyID = %q, want %s_1_<creation time>", latest, keyID)
+		}
+	})
+
+	t.Run("Token lookup and renew", func(t *testing.T) {
+		token, err := s.GetVaultToken(ctx)
+		if err != nil {
+			t.Fatalf("GetVaultToken() error: %v", err)
+		}
+		for _, field := range []string{"ttl", "creation_ttl", "issue_time", "expire_time", "explicit_max_ttl"} {
+			if _, ok := token.Data[field]; !ok {
+				t.Errorf("token lookup misses field %q", field)
+			}
+		}
+		if err := s.CheckTokenValidity(ctx); err != nil {
+			t.Fatalf("CheckTokenValidity() error: %v", err)
+		}
+		if err := s.RenewOwnToken(ctx, 3600); err != nil {
+			t.Fatalf("RenewOwnToken() error: %v", err)
+		}
+	})
+
+	t.Run("Transit encrypt and decrypt", func(t *testing.T) {
+		status, err := s.Status(ctx)
+		if err != nil || status.Healthz != healthOK {
+			t.Fatalf("Status() = %v, %v", status, err)
+		}
+		resp, err := s.Encrypt(ctx, "uid", []byte("secret"))
+		if err != nil {
+			t.Fatalf("Encrypt() error: %v", err)
+		}
+		if !strings.HasPrefix(string(resp.Ciphertext), "vault:v1:") {
+			t.Fatalf("ciphertext %q, want the vault:v1: prefix", resp.Ciphertext)
+		}
+		plaintext, err := s.Decrypt(ctx, "uid", &service.DecryptRequest{
+			Ciphertext:  resp.Ciphertext,
+			KeyID:       resp.KeyID,
+			Annotations: resp.Annotations,
+		})
+		if err != nil || string(plaintext) != "secret" {
+			t.Fatalf("Decrypt() = %q, %v", plaintext, err)
+		}
+	})
+}
+
+func TestOpenBaoNamespace(t *testing.T) {
+	root := newOpenBaoDevClient(t)
+	sealStatus, err := root.Sys().SealStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespace := fmt.Sprintf("kleidi-%d", time.Now().UnixNano())
+	s := &hvaultRemoteService{provider: openbaoProvider, Namespace: namespace}
+	config, err := openbaoConfig(root.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, _ := parseMajorMinor(sealStatus.Version)
+	if version[0] == 2 && version[1] < openbaoNamespacesVersion[1] {
+		if err := s.checkOpenBaoServer(config); err == nil {
+			t.Fatalf("namespace accepted by OpenBao %s", sealStatus.Version)
+		}
+		return
+	}
+	if err := s.checkOpenBaoServer(config); err != nil {
+		t.Fatalf("checkOpenBaoServer() error: %v", err)
+	}
+
+	if _, err := root.Logical().Write("sys/namespaces/"+namespace, nil); err != nil {
+		t.Fatalf("create namespace: %v", err)
+	}
+	t.Cleanup(func() { root.Logical().Delete("sys/namespaces/" + namespace) })
+	nsRoot, err := root.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nsRoot.SetToken(root.Token())
+	nsRoot.SetNamespace(namespace)
+
+	s = newOpenBaoDevService(t, nsRoot)
+	ctx := context.Background()
+	if status, err := s.Status(ctx); err != nil || status.Healthz != healthOK {
+		t.Fatalf("Status() in namespace = %v, %v", status, err)
+	}
+
+	// the transit key of the namespace is not visible from the root namespace.
+	root.ClearNamespace()
+	if key, err := root.Logical().Read(s.TransitPath + "/keys/kleidi"); err == nil && key != nil {
+		t.Fatalf("transit key of namespace %s visible from the root namespace", namespace)
+	}
+}
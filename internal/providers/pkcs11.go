@@ -9,10 +9,10 @@ package providers
 import (
 	"context"
 	"crypto/cipher"
-	"crypto/rand"
 	"fmt"
 
 	crypot11 "github.com/ThalesIgnite/crypto11"
+	"github.com/beezy-dev/kleidi/internal/tracing"
 	"k8s.io/kms/pkg/service"
 )
 
@@ -21,85 +21,128 @@ var _ service.Service = &pkcs11RemoteService{}
 type pkcs11RemoteService struct {
 	keyID string
 	aead  cipher.AEAD
+	ctx   *crypot11.Context
 }
 
-// NewPKCS11RemoteService creates a new PKCS11 remote service with SoftHSMv2 configuration file and keyID
-func NewPKCS11RemoteService(configFilePath, keyID string) (service.Service, error) {
-	ctx, err := crypot11.ConfigureFromFile(configFilePath)
+// readPKCS11Config strictly decodes the crypto11 configuration and checks it against the softhsm schema.
+func readPKCS11Config(source ConfigSource) (*crypot11.Config, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read softhsm config: %v", err)
+	}
+	// crypto11 matches the field names case-insensitively, e.g. "tokenLabel" or "TokenLabel".
+	config := &crypot11.Config{}
+	if err := decodeStrict(data, config, false); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid softhsm config %s: %v", source, err)
+	}
+	if err := validatePKCS11Config(config); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid softhsm config %s: %v", source, err)
+	}
+	return config, nil
+}
+
+func validatePKCS11Config(config *crypot11.Config) error {
+	if len(config.Path) == 0 {
+		return fmt.Errorf("field \"path\" to the PKCS#11 module is required")
+	}
+	if err := fileExists(config.Path); err != nil {
+		return fmt.Errorf("field \"path\": %v", err)
+	}
+	tokens := 0
+	if len(config.TokenSerial) != 0 {
+		tokens++
+	}
+	if len(config.TokenLabel) != 0 {
+		tokens++
+	}
+	if config.SlotNumber != nil {
+		tokens++
+	}
+	if tokens != 1 {
+		return fmt.Errorf("exactly one of the fields \"tokenSerial\", \"tokenLabel\" or \"slotNumber\" is required")
+	}
+	if len(config.Pin) == 0 && !config.LoginNotSupported {
+		return fmt.Errorf("field \"pin\" is required")
+	}
+	return nil
+}
+
+// NewPKCS11RemoteService creates a new PKCS11 remote service with SoftHSMv2 configuration and keyID
+func NewPKCS11RemoteService(source ConfigSource, keyID string) (service.Service, error) {
+	config, err := readPKCS11Config(source)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := crypot11.Configure(config)
 	if err != nil {
 		return nil, fmt.Errorf("/!\\ %v", err)
 	}
 
 	if len(keyID) == 0 {
+		ctx.Close()
 		return nil, fmt.Errorf("/!\\ invalid keyID")
 	}
 
 	remoteService := &pkcs11RemoteService{
 		keyID: keyID,
+		ctx:   ctx,
 	}
 
 	key, err := ctx.FindKey(nil, []byte(keyID))
 	if err != nil {
+		ctx.Close()
 		return nil, err
 	}
 
 	if key == nil {
+		ctx.Close()
 		return nil, fmt.Errorf("/!\\ key not found")
 	}
 
 	if remoteService.aead, err = key.NewGCM(); err != nil {
+		ctx.Close()
 		return nil, err
 	}
 
 	return remoteService, nil
 }
 
-func (s *pkcs11RemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
-	nonceSize := s.aead.NonceSize()
-	result := make([]byte, nonceSize+s.aead.Overhead()+len(plaintext))
+// Close logs out and releases the PKCS#11 sessions.
+func (s *pkcs11RemoteService) Close() error {
+	return s.ctx.Close()
+}
 
-	n, err := rand.Read(result[:nonceSize])
+func (s *pkcs11RemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	_, span := tracing.Tracer().Start(ctx, "pkcs11.Seal")
+	cipherText, err := sealAEAD(s.aead, s.keyID, plaintext)
+	span.End()
 	if err != nil {
 		return nil, err
 	}
 
-	if n != nonceSize {
-		return nil, fmt.Errorf("/!\\ unable to read sufficient random bytes")
-	}
-
-	cipherText := s.aead.Seal(result[nonceSize:nonceSize], result[:nonceSize], plaintext, []byte(s.keyID))
-
 	return &service.EncryptResponse{
-		Ciphertext: result[:nonceSize+len(cipherText)],
-		KeyID:      s.keyID,
-		Annotations: map[string][]byte{
-			annotationKey: []byte("1"),
-		},
+		Ciphertext:  cipherText,
+		KeyID:       s.keyID,
+		Annotations: aeadAnnotations(),
 	}, nil
 }
 
 func (s *pkcs11RemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
 
-	if len(req.Annotations) != 1 {
-		return nil, fmt.Errorf("/!\\ invalid annotations")
-	}
-
-	if v, ok := req.Annotations[annotationKey]; !ok || string(v) != "1" {
-		return nil, fmt.Errorf("/!\\ invalid version in annotations")
+	if err := checkAEADAnnotations(req.Annotations); err != nil {
+		return nil, err
 	}
 
 	if req.KeyID != s.keyID {
 		return nil, fmt.Errorf("/!\\ invalid keyID")
 	}
 
-	nonceSize := s.aead.NonceSize()
-
-	data := req.Ciphertext
-	if len(data) < nonceSize {
-		return nil, fmt.Errorf("/!\\ stored data was shorter than the required size")
-	}
-
-	return s.aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(s.keyID))
+	_, span := tracing.Tracer().Start(ctx, "pkcs11.Open")
+	defer span.End()
+	plaintext, err := openAEAD(s.aead, s.keyID, req.Ciphertext)
+	tracing.RecordError(span, err)
+	return plaintext, err
 }
 
 func (s *pkcs11RemoteService) Status(ctx context.Context) (*service.StatusResponse, error) {
@@ -0,0 +1,101 @@
+package providers
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Shamir secret sharing over GF(2^8), byte by byte, with the AES polynomial
+// x^8 + x^4 + x^3 + x + 1. A share is the value of the polynomials at x, followed
+// by x, in 1..255.
+
+// gf256Mul multiplies in GF(2^8), without branches on the secret values.
+func gf256Mul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ -(a>>7)&0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// gf256Inv inverts a non-zero element, as a^254.
+func gf256Inv(a byte) byte {
+	b := a
+	for range 6 {
+		b = gf256Mul(gf256Mul(b, b), a)
+	}
+	return gf256Mul(b, b)
+}
+
+// shamirSplit splits secret into n shares, any threshold of them recovering it.
+func shamirSplit(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 1 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("/!\\ invalid threshold %d of %d shares", threshold, n)
+	}
+
+	// the coefficients of the polynomial of each byte, the constant term excluded.
+	coefficients := make([]byte, len(secret)*(threshold-1))
+	defer clear(coefficients)
+	if _, err := rand.Read(coefficients); err != nil {
+		return nil, err
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		x := byte(i + 1)
+		share := make([]byte, len(secret)+1)
+		for j, s := range secret {
+			// Horner's method, from the highest degree.
+			var y byte
+			for _, c := range coefficients[j*(threshold-1) : (j+1)*(threshold-1)] {
+				y = gf256Mul(y, x) ^ c
+			}
+			share[j] = gf256Mul(y, x) ^ s
+		}
+		share[len(secret)] = x
+		shares[i] = share
+	}
+	return shares, nil
+}
+
+// shamirCombine recovers the secret from shares, by Lagrange interpolation at 0.
+// With less shares than the threshold, the result is random.
+func shamirCombine(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("/!\\ no shares to combine")
+	}
+	size := len(shares[0])
+	xs := make([]byte, len(shares))
+	for i, share := range shares {
+		if len(share) != size || size < 2 {
+			return nil, errors.New("/!\\ shares of different or invalid sizes")
+		}
+		xs[i] = share[size-1]
+		if xs[i] == 0 {
+			return nil, errors.New("/!\\ share with invalid x coordinate")
+		}
+		for _, x := range xs[:i] {
+			if x == xs[i] {
+				return nil, errors.New("/!\\ duplicate shares")
+			}
+		}
+	}
+
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		// the Lagrange basis polynomial of share i at 0: prod(xj / (xj - xi)).
+		basis := byte(1)
+		for j, x := range xs {
+			if j != i {
+				basis = gf256Mul(basis, gf256Mul(x, gf256Inv(x^xs[i])))
+			}
+		}
+		for k := range secret {
+			secret[k] ^= gf256Mul(share[k], basis)
+		}
+	}
+	return secret, nil
+}
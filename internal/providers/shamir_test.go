@@ -0,0 +1,47 @@
+package providers
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGF256(t *testing.T) {
+	// the multiplication example of FIPS 197, section 4.2.
+	if p := gf256Mul(0x57, 0x83); p != 0xc1 {
+		t.Fatalf("gf256Mul(0x57, 0x83) = %#x, want 0xc1", p)
+	}
+	for a := 1; a < 256; a++ {
+		if p := gf256Mul(byte(a), gf256Inv(byte(a))); p != 1 {
+			t.Fatalf("%#x * gf256Inv(%#x) = %#x, want 1", a, a, p)
+		}
+	}
+}
+
+func TestShamir(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	shares, err := shamirSplit(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("shamirSplit() error: %v", err)
+	}
+
+	// any 3 of the 5 shares recover the secret.
+	for i := range shares {
+		for j := i + 1; j < len(shares); j++ {
+			for k := j + 1; k < len(shares); k++ {
+				combined, err := shamirCombine([][]byte{shares[k], shares[i], shares[j]})
+				if err != nil || !bytes.Equal(combined, secret) {
+					t.Fatalf("shamirCombine(%d, %d, %d) = %q, %v", i, j, k, combined, err)
+				}
+			}
+		}
+	}
+	if combined, _ := shamirCombine(shares[:2]); bytes.Equal(combined, secret) {
+		t.Fatal("shamirCombine() of 2 shares recovered the secret")
+	}
+	if _, err := shamirCombine([][]byte{shares[0], shares[0], shares[1]}); err == nil {
+		t.Fatal("shamirCombine() of duplicate shares succeeded")
+	}
+	if _, err := shamirSplit(secret, 2, 3); err == nil {
+		t.Fatal("shamirSplit() with a threshold above the shares succeeded")
+	}
+}
@@ -0,0 +1,332 @@
+package providers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	thresholdProvider = "threshold"
+	// thresholdKEKSize is the size of the AES-256 key split into shares.
+	thresholdKEKSize = 32
+)
+
+var _ service.Service = &thresholdRemoteService{}
+
+// thresholdConfig is the threshold provider configuration.
+type thresholdConfig struct {
+	// Threshold is the number of members needed to decrypt.
+	Threshold int `json:"threshold"`
+	// Members are the providers wrapping a share each.
+	Members []memberConfig `json:"members"`
+}
+
+func (c *thresholdConfig) validate() error {
+	if len(c.Members) < 2 || len(c.Members) > 255 {
+		return fmt.Errorf("field \"members\" requires 2 to 255 members, got %d", len(c.Members))
+	}
+	if c.Threshold < 1 || c.Threshold > len(c.Members) {
+		return fmt.Errorf("field \"threshold\" must be between 1 and the %d members, got %d", len(c.Members), c.Threshold)
+	}
+	return validateMembers(c.Members)
+}
+
+func readThresholdConfig(source ConfigSource) (*thresholdConfig, error) {
+	data, err := source.Read()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ failed to read threshold config: %v", err)
+	}
+	config := &thresholdConfig{}
+	if err := decodeStrict(data, config, true); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid threshold config %s: %v", source, err)
+	}
+	if err := checkMembersKeys(data, "members"); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid threshold config %s: %v", source, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("/!\\ invalid threshold config %s: %v", source, err)
+	}
+	return config, nil
+}
+
+// thresholdShare is a share wrapped by a member, in the envelope of a ciphertext.
+type thresholdShare struct {
+	member string
+	req    *service.DecryptRequest
+}
+
+// marshalThresholdEnvelope encodes the ciphertext of the data key, then the shares:
+// the envelope version, the sealed data key prefixed with its length, the count of
+// shares, then the member name and the envelope of each wrapped share.
+func marshalThresholdEnvelope(sealed []byte, names []string, wrapped []*service.EncryptResponse) []byte {
+	b := []byte{envelopeVersion}
+	b = appendLengthPrefixed(b, sealed)
+	b = append(b, byte(len(wrapped)))
+	for i, resp := range wrapped {
+		b = appendLengthPrefixed(b, []byte(names[i]))
+		b = appendLengthPrefixed(b, marshalEnvelope(resp))
+	}
+	return b
+}
+
+// unmarshalThresholdEnvelope decodes the output of marshalThresholdEnvelope.
+func unmarshalThresholdEnvelope(data []byte) ([]byte, []thresholdShare, error) {
+	r := &envelopeReader{data: data}
+	if err := r.readVersion(); err != nil {
+		return nil, nil, err
+	}
+	sealed, err := r.readLengthPrefixed()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(r.data) == 0 {
+		return nil, nil, errTruncatedEnvelope
+	}
+	shares := make([]thresholdShare, r.data[0])
+	r.data = r.data[1:]
+	for i := range shares {
+		name, err := r.readLengthPrefixed()
+		if err != nil {
+			return nil, nil, err
+		}
+		envelope, err := r.readLengthPrefixed()
+		if err != nil {
+			return nil, nil, err
+		}
+		req, err := unmarshalEnvelope(envelope)
+		if err != nil {
+			return nil, nil, err
+		}
+		shares[i] = thresholdShare{member: string(name), req: req}
+	}
+	if len(r.data) != 0 {
+		return nil, nil, errors.New("/!\\ trailing data in envelope")
+	}
+	return sealed, shares, nil
+}
+
+// thresholdKeyID joins the KeyIDs of the members, in their configuration order.
+func thresholdKeyID(keyIDs []string) string {
+	return strings.Join(keyIDs, layeredKeyIDSeparator)
+}
+
+// thresholdRemoteService encrypts each data key with a random key, split with
+// Shamir secret sharing into a share per member, so that any threshold of the
+// members decrypt it. The ciphertext holds the data key sealed with AES-GCM,
+// authenticated with the KeyID, then the shares wrapped by the members.
+type thresholdRemoteService struct {
+	members   []member
+	threshold int
+}
+
+// NewThresholdRemoteService creates a threshold remote service, building each member.
+func NewThresholdRemoteService(source ConfigSource) (service.Service, error) {
+	config, err := readThresholdConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	members, err := newMembers(config.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Info("INFO: threshold provider ready", logger.Provider(thresholdProvider),
+		zap.Int("threshold", config.Threshold), zap.Int("members", len(members)))
+	return &thresholdRemoteService{members: members, threshold: config.Threshold}, nil
+}
+
+// Close closes every member.
+func (s *thresholdRemoteService) Close() error {
+	return closeMembers(s.members)
+}
+
+// Encrypt wraps a share with each member, and succeeds once at least threshold
+// of them are wrapped, so that up to n-threshold members may be unavailable. The
+// envelope and the KeyID only hold the members holding a share.
+func (s *thresholdRemoteService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	kek := make([]byte, thresholdKEKSize)
+	defer clear(kek)
+	if _, err := rand.Read(kek); err != nil {
+		return nil, err
+	}
+	shares, err := shamirSplit(kek, len(s.members), s.threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, share := range shares {
+			clear(share)
+		}
+	}()
+
+	wrapped := make([]*service.EncryptResponse, len(s.members))
+	errs := make([]error, len(s.members))
+	var wg sync.WaitGroup
+	for i, m := range s.members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wrapped[i], errs[i] = m.service.Encrypt(ctx, uid, shares[i])
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("member %s: %v", m.name, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	var names, keyIDs []string
+	var held []*service.EncryptResponse
+	for i, m := range s.members {
+		if errs[i] != nil {
+			continue
+		}
+		names = append(names, m.name)
+		keyIDs = append(keyIDs, wrapped[i].KeyID)
+		held = append(held, wrapped[i])
+	}
+	if len(held) < s.threshold {
+		return nil, fmt.Errorf("/!\\ %d of the %d shares wrapped, %d required: %v", len(held), len(s.members), s.threshold, errors.Join(errs...))
+	}
+	if len(held) < len(s.members) {
+		zap.L().Warn("threshold provider degraded, encrypting with the available members", logger.Provider(thresholdProvider), logger.Op("encrypt"),
+			zap.Strings("holders", names), zap.Int("members", len(s.members)), zap.Error(errors.Join(errs...)))
+	}
+	keyID := thresholdKeyID(keyIDs)
+
+	aead, err := newThresholdAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealAEAD(aead, keyID, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &service.EncryptResponse{
+		Ciphertext: marshalThresholdEnvelope(sealed, names, held),
+		KeyID:      keyID,
+	}, nil
+}
+
+func newThresholdAEAD(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Decrypt unwraps the shares concurrently, and combines the first threshold of
+// them to decrypt, without waiting for the unavailable members.
+func (s *thresholdRemoteService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	sealed, shares, err := unmarshalThresholdEnvelope(req.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	keyIDs := make([]string, len(shares))
+	for i, share := range shares {
+		keyIDs[i] = share.req.KeyID
+	}
+	if thresholdKeyID(keyIDs) != req.KeyID {
+		return nil, fmt.Errorf("/!\\ invalid keyID")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		share []byte
+		err   error
+	}
+	results := make(chan result, len(shares))
+	for _, share := range shares {
+		i := slices.IndexFunc(s.members, func(m member) bool { return m.name == share.member })
+		if i < 0 {
+			results <- result{err: fmt.Errorf("unknown member %q", share.member)}
+			continue
+		}
+		go func() {
+			plaintext, err := s.members[i].service.Decrypt(ctx, uid, share.req)
+			if err != nil {
+				err = fmt.Errorf("member %s: %v", share.member, err)
+			}
+			results <- result{share: plaintext, err: err}
+		}()
+	}
+
+	var unwrapped [][]byte
+	defer func() {
+		for _, share := range unwrapped {
+			clear(share)
+		}
+	}()
+	var errs []error
+	received := 0
+	for received < len(shares) && len(unwrapped) < s.threshold {
+		r := <-results
+		received++
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		unwrapped = append(unwrapped, r.share)
+	}
+	// the shares unwrapped after the threshold are zeroed as they arrive.
+	go func(remaining int) {
+		for range remaining {
+			clear((<-results).share)
+		}
+	}(len(shares) - received)
+	if len(unwrapped) < s.threshold {
+		return nil, fmt.Errorf("/!\\ %d of the %d shares unwrapped, %d required: %v", len(unwrapped), len(shares), s.threshold, errors.Join(errs...))
+	}
+
+	kek, err := shamirCombine(unwrapped)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(kek)
+	aead, err := newThresholdAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	return openAEAD(aead, req.KeyID, sealed)
+}
+
+// Status joins the KeyIDs of the reachable members, like the KeyID of Encrypt, so
+// that the API server encrypts its data keys again once a member is back. It is
+// healthy, degraded, while at least threshold members are reachable.
+func (s *thresholdRemoteService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	statuses, healthz := membersStatus(ctx, s.members)
+	var keyIDs []string
+	for _, status := range statuses {
+		if status.Healthz == healthOK {
+			keyIDs = append(keyIDs, status.KeyID)
+		}
+	}
+	if healthz != healthOK {
+		degraded := fmt.Sprintf("%d/%d shares reachable, %d required: %s", len(keyIDs), len(statuses), s.threshold, healthz)
+		if len(keyIDs) >= s.threshold {
+			zap.L().Warn("threshold provider degraded", logger.Provider(thresholdProvider), logger.Op("status"), zap.String("healthz", degraded))
+			healthz = healthOK
+		} else {
+			zap.L().Error("threshold provider below its threshold", logger.Provider(thresholdProvider), logger.Op("status"), zap.String("healthz", degraded))
+			healthz = degraded
+		}
+	}
+
+	return &service.StatusResponse{
+		Version: "v2",
+		Healthz: healthz,
+		KeyID:   thresholdKeyID(keyIDs),
+	}, nil
+}
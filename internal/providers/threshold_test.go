@@ -0,0 +1,195 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"k8s.io/kms/pkg/service"
+)
+
+// unavailableService fails every operation of its service once down.
+type unavailableService struct {
+	service.Service
+	down atomic.Bool
+}
+
+func (s *unavailableService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	if s.down.Load() {
+		return nil, errors.New("unavailable")
+	}
+	return s.Service.Encrypt(ctx, uid, plaintext)
+}
+
+func (s *unavailableService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	if s.down.Load() {
+		return nil, errors.New("unavailable")
+	}
+	return s.Service.Decrypt(ctx, uid, req)
+}
+
+func (s *unavailableService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	if s.down.Load() {
+		return nil, errors.New("unavailable")
+	}
+	return s.Service.Status(ctx)
+}
+
+// newThresholdTest returns a threshold provider of n localkey members in dir, made
+// unavailable on demand.
+func newThresholdTest(t *testing.T, dir string, threshold, n int) (*thresholdRemoteService, []*unavailableService) {
+	t.Helper()
+	members := make([]string, n)
+	for i := range members {
+		members[i] = localkeyMember(dir, fmt.Sprintf("site-%d", i))
+	}
+	built, err := NewThresholdRemoteService(ConfigSource{Inline: []byte(fmt.Sprintf(`{"threshold": %d, "members": [%s]}`,
+		threshold, strings.Join(members, ", ")))})
+	if err != nil {
+		t.Fatalf("NewThresholdRemoteService() error: %v", err)
+	}
+	t.Cleanup(func() { Close(built) })
+
+	svc := built.(*thresholdRemoteService)
+	sites := make([]*unavailableService, len(svc.members))
+	for i := range svc.members {
+		sites[i] = &unavailableService{Service: svc.members[i].service}
+		svc.members[i].service = sites[i]
+	}
+	return svc, sites
+}
+
+func TestThresholdProvider(t *testing.T) {
+	ctx := context.Background()
+	svc, sites := newThresholdTest(t, t.TempDir(), 2, 3)
+	decrypt := func(resp *service.EncryptResponse) error {
+		t.Helper()
+		plaintext, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: resp.Ciphertext, KeyID: resp.KeyID, Annotations: resp.Annotations})
+		if err == nil && string(plaintext) != "secret" {
+			t.Fatalf("Decrypt() = %q", plaintext)
+		}
+		return err
+	}
+
+	status, err := svc.Status(ctx)
+	if err != nil || status.Healthz != healthOK {
+		t.Fatalf("Status() = %v, %v", status, err)
+	}
+	resp, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil || resp.KeyID != status.KeyID {
+		t.Fatalf("Encrypt() = %v, %v", resp, err)
+	}
+	if err := decrypt(resp); err != nil {
+		t.Fatalf("Decrypt() error: %v", err)
+	}
+	if _, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: resp.Ciphertext, KeyID: "other+" + resp.KeyID}); err == nil {
+		t.Fatal("Decrypt() with another KeyID succeeded")
+	}
+
+	// a member down: healthy, degraded, with the KeyID of the reachable members.
+	sites[0].down.Store(true)
+	degraded, err := svc.Status(ctx)
+	if err != nil || degraded.Healthz != healthOK || degraded.KeyID == status.KeyID {
+		t.Fatalf("Status() with a member down = %v, %v", degraded, err)
+	}
+	partial, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+	if err != nil || partial.KeyID != degraded.KeyID {
+		t.Fatalf("Encrypt() with a member down = %v, %v", partial, err)
+	}
+	if err := decrypt(resp); err != nil {
+		t.Fatalf("Decrypt() with a member down error: %v", err)
+	}
+	if err := decrypt(partial); err != nil {
+		t.Fatalf("Decrypt() of the shares of 2 members error: %v", err)
+	}
+
+	// the member is back: the KeyID changes, so that the data keys are encrypted again.
+	sites[0].down.Store(false)
+	if status, _ := svc.Status(ctx); status.KeyID == degraded.KeyID {
+		t.Fatalf("Status() after the member is back = %v", status)
+	}
+	if err := decrypt(partial); err != nil {
+		t.Fatalf("Decrypt() after the member is back error: %v", err)
+	}
+
+	// below the threshold, encrypting and decrypting fail.
+	sites[0].down.Store(true)
+	sites[1].down.Store(true)
+	if status, _ := svc.Status(ctx); status.Healthz != "1/3 shares reachable, 2 required: member site-0: nok" {
+		t.Fatalf("Status() with 2 members down = %v", status)
+	}
+	if _, err := svc.Encrypt(ctx, "uid", []byte("secret")); err == nil || !strings.Contains(err.Error(), "1 of the 3 shares wrapped, 2 required") {
+		t.Fatalf("Encrypt() with 2 members down = %v", err)
+	}
+	if err := decrypt(resp); err == nil || !strings.Contains(err.Error(), "1 of the 3 shares unwrapped, 2 required") {
+		t.Fatalf("Decrypt() with 2 members down = %v", err)
+	}
+}
+
+func TestThresholdMembersDown(t *testing.T) {
+	testCases := []struct {
+		name      string
+		threshold int
+		members   int
+	}{
+		{name: "1 of 2", threshold: 1, members: 2},
+		{name: "2 of 3", threshold: 2, members: 3},
+		{name: "3 of 5", threshold: 3, members: 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			svc, sites := newThresholdTest(t, t.TempDir(), tc.threshold, tc.members)
+			full, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+			if err != nil {
+				t.Fatalf("Encrypt() error: %v", err)
+			}
+
+			// n-k members down, the last ones.
+			for _, site := range sites[tc.threshold:] {
+				site.down.Store(true)
+			}
+			status, err := svc.Status(ctx)
+			if err != nil || status.Healthz != healthOK {
+				t.Fatalf("Status() with %d members down = %v, %v", tc.members-tc.threshold, status, err)
+			}
+			partial, err := svc.Encrypt(ctx, "uid", []byte("secret"))
+			if err != nil || partial.KeyID != status.KeyID {
+				t.Fatalf("Encrypt() with %d members down = %v, %v", tc.members-tc.threshold, partial, err)
+			}
+			for _, resp := range []*service.EncryptResponse{full, partial} {
+				plaintext, err := svc.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: resp.Ciphertext, KeyID: resp.KeyID})
+				if err != nil || string(plaintext) != "secret" {
+					t.Fatalf("Decrypt(%s) = %q, %v", resp.KeyID, plaintext, err)
+				}
+			}
+
+			// one more member down.
+			sites[0].down.Store(true)
+			if status, _ := svc.Status(ctx); status.Healthz == healthOK {
+				t.Fatalf("Status() below the threshold = %v", status)
+			}
+			if _, err := svc.Encrypt(ctx, "uid", []byte("secret")); err == nil {
+				t.Fatal("Encrypt() below the threshold succeeded")
+			}
+		})
+	}
+}
+
+func TestValidateThresholdConfig(t *testing.T) {
+	dir := t.TempDir()
+	a, b, c := localkeyMember(dir, "site-a"), localkeyMember(dir, "site-b"), localkeyMember(dir, "site-c")
+	testCases := []configTestCase{
+		{name: "2 of 3", input: `{"threshold": 2, "members": [` + a + `, ` + b + `, ` + c + `]}`},
+		{name: "Single member", input: `{"threshold": 1, "members": [` + a + `]}`, expectErr: `requires 2 to 255 members, got 1`},
+		{name: "Threshold above members", input: `{"threshold": 3, "members": [` + a + `, ` + b + `]}`, expectErr: `field "threshold" must be between 1 and the 2 members, got 3`},
+		{name: "Missing threshold", input: `{"members": [` + a + `, ` + b + `]}`, expectErr: `got 0`},
+		{name: "Duplicate member", input: `{"threshold": 2, "members": [` + a + `, ` + a + `]}`, expectErr: `member name "site-a" is not unique`},
+	}
+
+	testValidateConfig(t, "threshold", testCases)
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/kms/pkg/service"
+)
+
+var _ service.Service = &tracedService{}
+
+// tracedService opens a span for every call made to the wrapped provider.
+type tracedService struct {
+	provider string
+	next     service.Service
+}
+
+// NewTracedService wraps next so that each KMS operation is recorded as a span carrying
+// the KMS uid, allowing the correlation with the API server logs.
+func NewTracedService(provider string, next service.Service) service.Service {
+	return &tracedService{
+		provider: provider,
+		next:     next,
+	}
+}
+
+func (s *tracedService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	ctx, span := s.start(ctx, "kms.Encrypt", attribute.String("kms.uid", uid))
+	defer span.End()
+
+	resp, err := s.next.Encrypt(ctx, uid, plaintext)
+	if err == nil {
+		span.SetAttributes(attribute.String("kms.key_id", resp.KeyID))
+	}
+	RecordError(span, err)
+	return resp, err
+}
+
+func (s *tracedService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	ctx, span := s.start(ctx, "kms.Decrypt", attribute.String("kms.uid", uid), attribute.String("kms.key_id", req.KeyID))
+	defer span.End()
+
+	plaintext, err := s.next.Decrypt(ctx, uid, req)
+	RecordError(span, err)
+	return plaintext, err
+}
+
+func (s *tracedService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	ctx, span := s.start(ctx, "kms.Status")
+	defer span.End()
+
+	resp, err := s.next.Status(ctx)
+	if resp != nil {
+		span.SetAttributes(attribute.String("kms.healthz", resp.Healthz), attribute.String("kms.key_id", resp.KeyID))
+	}
+	RecordError(span, err)
+	return resp, err
+}
+
+func (s *tracedService) start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("kms.provider", s.provider))
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// RecordError records err, if any, on span and flags it as failed.
+func RecordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
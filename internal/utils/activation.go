@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/activation"
+	"go.uber.org/zap"
+)
+
+// IsAbstractSocket reports whether addr is in the Linux abstract namespace, which
+// has no file on disk: it can not be removed, stat'ed, nor have a mode or an owner.
+func IsAbstractSocket(addr string) bool {
+	return strings.HasPrefix(addr, "@")
+}
+
+// SystemdListener returns the unix socket passed by systemd socket activation
+// (LISTEN_FDS), or nil when kleidi was not socket activated.
+func SystemdListener() (net.Listener, error) {
+	listeners, err := activation.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("/!\\ invalid systemd socket activation: %v", err)
+	}
+	if len(listeners) == 0 {
+		return nil, nil
+	}
+	if len(listeners) != 1 || listeners[0] == nil {
+		return nil, fmt.Errorf("/!\\ invalid systemd socket activation: expecting a single stream socket, got %d", len(listeners))
+	}
+	if _, ok := listeners[0].(*net.UnixListener); !ok {
+		return nil, fmt.Errorf("/!\\ invalid systemd socket activation: %s is not a unix socket", listeners[0].Addr())
+	}
+
+	zap.L().Info("INFO: socket passed by systemd", zap.String("socket", listeners[0].Addr().String()))
+	return listeners[0], nil
+}
@@ -0,0 +1,279 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"github.com/beezy-dev/kleidi/internal/metrics"
+	"go.uber.org/zap"
+	"k8s.io/kms/pkg/service"
+)
+
+const (
+	// decryptCacheSweepInterval bounds the period between two sweeps of the expired plaintexts.
+	decryptCacheSweepInterval = 30 * time.Second
+	// decryptCacheCallTimeOut bounds a Decrypt shared by concurrent misses, which
+	// outlives the callers giving up.
+	decryptCacheCallTimeOut = 30 * time.Second
+)
+
+// DecryptCacheOptions holds the settings of the decrypt cache, disabled when MaxEntries is 0.
+type DecryptCacheOptions struct {
+	MaxEntries int
+	TTL        time.Duration
+}
+
+// Enabled reports whether the decrypt cache is enabled.
+func (o DecryptCacheOptions) Enabled() bool {
+	return o.MaxEntries > 0
+}
+
+var _ service.Service = &decryptCache{}
+
+// decryptCache serves the Decrypt results of the recently decrypted ciphertexts from
+// memory, e.g. when several API servers restart and decrypt the same DEK seeds. It is
+// a LRU bounded by maxEntries, each plaintext expiring ttl after its decryption, and
+// zeroed when evicted. The concurrent misses of a ciphertext share a single Decrypt.
+type decryptCache struct {
+	provider   string
+	next       service.Service
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[[sha256.Size]byte]*list.Element
+	// calls are the Decrypt calls in flight, by key.
+	calls map[[sha256.Size]byte]*decryptCall
+	// generation is incremented by purge: the results of the calls started before
+	// are not cached.
+	generation uint64
+}
+
+// decryptCall is a Decrypt shared by the concurrent misses of a ciphertext. Its
+// plaintext is zeroed once every waiting caller copied it.
+type decryptCall struct {
+	done      chan struct{}
+	plaintext []byte
+	err       error
+	// finished and waiters, the callers yet to copy the result, are guarded by mu.
+	finished bool
+	waiters  int
+}
+
+// decryptCacheEntry is a cached plaintext, owned by the cache.
+type decryptCacheEntry struct {
+	key       [sha256.Size]byte
+	plaintext []byte
+	expires   time.Time
+}
+
+func newDecryptCache(provider string, next service.Service, opts DecryptCacheOptions) *decryptCache {
+	return &decryptCache{
+		provider:   provider,
+		next:       next,
+		maxEntries: opts.MaxEntries,
+		ttl:        opts.TTL,
+		now:        time.Now,
+		lru:        list.New(),
+		entries:    make(map[[sha256.Size]byte]*list.Element),
+		calls:      make(map[[sha256.Size]byte]*decryptCall),
+	}
+}
+
+// decryptCacheKey hashes the KeyID, the annotations and the ciphertext of req, each
+// prefixed with its length, so that a request differing in any of them is a miss.
+func decryptCacheKey(req *service.DecryptRequest) [sha256.Size]byte {
+	h := sha256.New()
+	write := func(b []byte) {
+		h.Write(binary.AppendUvarint(nil, uint64(len(b))))
+		h.Write(b)
+	}
+	write([]byte(req.KeyID))
+	names := make([]string, 0, len(req.Annotations))
+	for name := range req.Annotations {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		write([]byte(name))
+		write(req.Annotations[name])
+	}
+	write(req.Ciphertext)
+
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+func (c *decryptCache) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	return c.next.Encrypt(ctx, uid, plaintext)
+}
+
+func (c *decryptCache) Status(ctx context.Context) (*service.StatusResponse, error) {
+	return c.next.Status(ctx)
+}
+
+// Decrypt returns a copy of the cached plaintext, or decrypts with the provider and
+// caches the result. The errors are not cached.
+func (c *decryptCache) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	key := decryptCacheKey(req)
+	if plaintext, ok := c.get(key); ok {
+		metrics.DecryptCacheRequests.WithLabelValues(c.provider, "hit").Inc()
+		return plaintext, nil
+	}
+	metrics.DecryptCacheRequests.WithLabelValues(c.provider, "miss").Inc()
+
+	c.mu.Lock()
+	call, ok := c.calls[key]
+	if !ok {
+		call = &decryptCall{done: make(chan struct{})}
+		c.calls[key] = call
+		// the shared Decrypt is not canceled with the caller starting it, as the other
+		// callers wait on its result.
+		go c.do(context.WithoutCancel(ctx), key, call, c.generation, uid, req)
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	var err error
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	call.waiters--
+	var plaintext []byte
+	if err == nil {
+		plaintext, err = slices.Clone(call.plaintext), call.err
+	}
+	if call.finished && call.waiters == 0 {
+		clear(call.plaintext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// do decrypts with the provider for the callers of call, and caches the plaintext
+// unless the cache was purged since the call started.
+func (c *decryptCache) do(ctx context.Context, key [sha256.Size]byte, call *decryptCall, generation uint64, uid string, req *service.DecryptRequest) {
+	ctx, cancel := context.WithTimeout(ctx, decryptCacheCallTimeOut)
+	defer cancel()
+	plaintext, err := c.next.Decrypt(ctx, uid, req)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+	if err == nil && generation == c.generation {
+		c.putLocked(key, plaintext)
+	}
+	call.plaintext, call.err, call.finished = plaintext, err, true
+	if call.waiters == 0 {
+		clear(plaintext)
+	}
+	close(call.done)
+}
+
+// get returns a copy of the plaintext cached for key, unless it expired.
+func (c *decryptCache) get(key [sha256.Size]byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*decryptCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.evict(elem, "expired")
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return slices.Clone(entry.plaintext), true
+}
+
+// putLocked caches a copy of plaintext for key, evicting the least recently used
+// plaintext when the cache is full. The caller holds mu.
+func (c *decryptCache) putLocked(key [sha256.Size]byte, plaintext []byte) {
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*decryptCacheEntry)
+		clear(entry.plaintext)
+		entry.plaintext, entry.expires = slices.Clone(plaintext), c.now().Add(c.ttl)
+		c.lru.MoveToFront(elem)
+		return
+	}
+	for c.lru.Len() >= c.maxEntries {
+		c.evict(c.lru.Back(), "capacity")
+	}
+	c.entries[key] = c.lru.PushFront(&decryptCacheEntry{
+		key:       key,
+		plaintext: slices.Clone(plaintext),
+		expires:   c.now().Add(c.ttl),
+	})
+	metrics.DecryptCacheEntries.WithLabelValues(c.provider).Set(float64(c.lru.Len()))
+}
+
+// evict zeroes and drops the plaintext of elem. The caller holds mu.
+func (c *decryptCache) evict(elem *list.Element, reason string) {
+	entry := c.lru.Remove(elem).(*decryptCacheEntry)
+	delete(c.entries, entry.key)
+	clear(entry.plaintext)
+	metrics.DecryptCacheEvictions.WithLabelValues(c.provider, reason).Inc()
+	metrics.DecryptCacheEntries.WithLabelValues(c.provider).Set(float64(c.lru.Len()))
+}
+
+// sweep evicts the expired plaintexts, so that none stays in memory past its ttl.
+func (c *decryptCache) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if !now.Before(elem.Value.(*decryptCacheEntry).expires) {
+			c.evict(elem, "expired")
+		}
+		elem = next
+	}
+}
+
+// purge evicts every plaintext, e.g. once the provider configuration is reloaded.
+// The calls in flight are no longer joined, and their results not cached.
+func (c *decryptCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	clear(c.calls)
+	for c.lru.Len() > 0 {
+		c.evict(c.lru.Back(), "purged")
+	}
+}
+
+// run sweeps the expired plaintexts until ctx is done, then purges the cache.
+func (c *decryptCache) run(ctx context.Context) {
+	zap.L().Info("INFO: decrypt cache enabled", logger.Provider(c.provider),
+		zap.Int("maxEntries", c.maxEntries), zap.Duration("ttl", c.ttl))
+	ticker := time.NewTicker(min(c.ttl, decryptCacheSweepInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.sweep()
+		case <-ctx.Done():
+			c.purge()
+			return
+		}
+	}
+}
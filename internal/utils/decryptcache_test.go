@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/kms/pkg/service"
+)
+
+// countingService decrypts like fakeService, counting the calls and blocking them
+// until release is closed or their context is done, when set.
+type countingService struct {
+	*fakeService
+	decrypts atomic.Int32
+	release  chan struct{}
+	fail     atomic.Bool
+	// last is the last plaintext returned.
+	last []byte
+}
+
+func (s *countingService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	s.decrypts.Add(1)
+	if s.release != nil {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if s.fail.Load() {
+		return nil, errors.New("unavailable")
+	}
+	s.last = append([]byte{}, req.Ciphertext...)
+	return s.last, nil
+}
+
+func TestDecryptCache(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingService{fakeService: newFakeService("v1")}
+	cache := newDecryptCache("fake", backend, DecryptCacheOptions{MaxEntries: 2, TTL: time.Minute})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	decrypt := func(ciphertext, keyID string, want int32) {
+		t.Helper()
+		plaintext, err := cache.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: []byte(ciphertext), KeyID: keyID})
+		if err != nil || string(plaintext) != ciphertext {
+			t.Fatalf("Decrypt(%s) = %q, %v", ciphertext, plaintext, err)
+		}
+		// the caller owns the returned plaintext.
+		clear(plaintext)
+		if n := backend.decrypts.Load(); n != want {
+			t.Fatalf("Decrypt(%s) reached the provider %d times, want %d", ciphertext, n, want)
+		}
+	}
+
+	decrypt("a", "v1", 1)
+	decrypt("a", "v1", 1)
+	// the KeyID is part of the key.
+	decrypt("a", "v2", 2)
+
+	// the least recently used plaintext is evicted and zeroed.
+	decrypt("a", "v1", 2)
+	decrypt("b", "v1", 3)
+	elem := cache.entries[decryptCacheKey(&service.DecryptRequest{Ciphertext: []byte("a"), KeyID: "v2"})]
+	if elem != nil {
+		t.Fatal("least recently used plaintext still cached")
+	}
+	evicted := cache.lru.Back().Value.(*decryptCacheEntry)
+	decrypt("c", "v1", 4)
+	if string(evicted.plaintext) != "\x00" {
+		t.Fatalf("evicted plaintext = %q, want it zeroed", evicted.plaintext)
+	}
+
+	// the plaintexts expire after the ttl, and are swept.
+	now = now.Add(2 * time.Minute)
+	decrypt("c", "v1", 5)
+	now = now.Add(2 * time.Minute)
+	cache.sweep()
+	if cache.lru.Len() != 0 || len(cache.entries) != 0 {
+		t.Fatalf("%d plaintexts cached after the sweep, want 0", cache.lru.Len())
+	}
+
+	// the errors are not cached.
+	backend.fail.Store(true)
+	if _, err := cache.Decrypt(ctx, "uid", &service.DecryptRequest{Ciphertext: []byte("d"), KeyID: "v1"}); err == nil {
+		t.Fatal("Decrypt() with the provider down succeeded")
+	}
+	backend.fail.Store(false)
+	decrypt("d", "v1", 7)
+
+	cache.purge()
+	decrypt("d", "v1", 8)
+}
+
+func TestDecryptCacheConcurrentMisses(t *testing.T) {
+	backend := &countingService{fakeService: newFakeService("v1"), release: make(chan struct{})}
+	cache := newDecryptCache("fake", backend, DecryptCacheOptions{MaxEntries: 10, TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			plaintext, err := cache.Decrypt(context.Background(), "uid", &service.DecryptRequest{Ciphertext: []byte("seed"), KeyID: "v1"})
+			if err != nil || string(plaintext) != "seed" {
+				t.Errorf("Decrypt() = %q, %v", plaintext, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+	if n := backend.decrypts.Load(); n != 1 {
+		t.Fatalf("concurrent misses reached the provider %d times, want 1", n)
+	}
+	// the plaintext shared by the callers is zeroed once copied by all of them.
+	if string(backend.last) != "\x00\x00\x00\x00" {
+		t.Fatalf("shared plaintext = %q, want it zeroed", backend.last)
+	}
+}
+
+func TestDecryptCacheFirstCallerCanceled(t *testing.T) {
+	backend := &countingService{fakeService: newFakeService("v1"), release: make(chan struct{})}
+	cache := newDecryptCache("fake", backend, DecryptCacheOptions{MaxEntries: 10, TTL: time.Minute})
+	req := &service.DecryptRequest{Ciphertext: []byte("seed"), KeyID: "v1"}
+
+	// the first caller starts the shared Decrypt, then gives up.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.Decrypt(ctx, "uid", req)
+		first <- err
+	}()
+	for backend.decrypts.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	second := make(chan []byte, 1)
+	go func() {
+		plaintext, err := cache.Decrypt(context.Background(), "uid", req)
+		if err != nil {
+			t.Errorf("Decrypt() error: %v", err)
+		}
+		second <- plaintext
+	}()
+	if err := <-first; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Decrypt() of the first caller = %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(backend.release)
+	if plaintext := <-second; string(plaintext) != "seed" {
+		t.Fatalf("Decrypt() of the second caller = %q, want %q", plaintext, "seed")
+	}
+	if n := backend.decrypts.Load(); n != 1 {
+		t.Fatalf("the callers reached the provider %d times, want 1", n)
+	}
+}
+
+func TestDecryptCachePurgeInFlight(t *testing.T) {
+	backend := &countingService{fakeService: newFakeService("v1"), release: make(chan struct{})}
+	cache := newDecryptCache("fake", backend, DecryptCacheOptions{MaxEntries: 10, TTL: time.Minute})
+	req := &service.DecryptRequest{Ciphertext: []byte("seed"), KeyID: "v1"}
+
+	// a Decrypt against the previous instance is in flight when the cache is purged.
+	done := make(chan error, 1)
+	go func() {
+		_, err := cache.Decrypt(context.Background(), "uid", req)
+		done <- err
+	}()
+	for backend.decrypts.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cache.purge()
+	close(backend.release)
+	if err := <-done; err != nil {
+		t.Fatalf("Decrypt() error: %v", err)
+	}
+
+	// its result is not cached for the new instance.
+	if n := cache.lru.Len(); n != 0 {
+		t.Fatalf("%d plaintexts cached after the purge, want 0", n)
+	}
+	if _, err := cache.Decrypt(context.Background(), "uid", req); err != nil || backend.decrypts.Load() != 2 {
+		t.Fatalf("Decrypt() after the purge = %v, reached the provider %d times, want 2", err, backend.decrypts.Load())
+	}
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	kmsapi "k8s.io/kms/apis/v2"
+	"k8s.io/kms/pkg/service"
+)
+
+// grpcServer serves the KMSv2 API like service.GRPCService while owning the
+// underlying grpc.Server, so that server options such as the OpenTelemetry
+// stats handler extracting the API server trace context can be set.
+type grpcServer struct {
+	addr       string
+	socketOpts SocketOptions
+	server     *grpc.Server
+	// activated is the listener passed by systemd socket activation, if any. Its
+	// socket file is owned by systemd.
+	activated net.Listener
+
+	mu sync.Mutex
+	// ln is the current listener and socket the file it created, to detect its removal or replacement.
+	ln     net.Listener
+	socket os.FileInfo
+}
+
+func newGRPCServer(addr string, socketOpts SocketOptions, kmsService service.Service, extra ...grpc.ServerOption) *grpcServer {
+	gs := grpc.NewServer(append([]grpc.ServerOption{
+		grpc.ConnectionTimeout(socketTimeOut),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	}, extra...)...)
+	kmsapi.RegisterKeyManagementServiceServer(gs, service.NewGRPCService(addr, socketTimeOut, kmsService))
+
+	return &grpcServer{
+		addr:       addr,
+		socketOpts: socketOpts,
+		server:     gs,
+	}
+}
+
+// ListenAndServe accepts incoming connections on the unix socket. It is a blocking method.
+func (s *grpcServer) ListenAndServe() error {
+	if s.activated != nil {
+		s.mu.Lock()
+		s.ln = s.activated
+		s.mu.Unlock()
+		return s.serve(s.activated)
+	}
+
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	return s.serve(ln)
+}
+
+// listen creates the unix socket with its mode and ownership, and makes it the current listener.
+func (s *grpcServer) listen() (net.Listener, error) {
+	ln, err := listenUnix(s.addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.socketOpts.apply(s.addr); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	socket, err := os.Stat(s.addr)
+	if err != nil && !IsAbstractSocket(s.addr) {
+		ln.Close()
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ln, s.socket = ln, socket
+	return ln, nil
+}
+
+// serve blocks serving ln. It returns without error once ln is replaced by a recreated
+// listener, or when the server is shut down.
+func (s *grpcServer) serve(ln net.Listener) error {
+	err := s.server.Serve(ln)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ln != s.ln {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting new connections and blocks until all pending RPCs are finished.
+func (s *grpcServer) Shutdown() {
+	s.server.GracefulStop()
+}
+
+// Drain stops accepting new connections and waits up to timeout for the pending RPCs
+// to finish. The remaining ones are then cancelled and Drain reports false.
+func (s *grpcServer) Drain(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		s.server.Stop()
+		<-done
+		return false
+	}
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/beezy-dev/kleidi/internal/client"
+)
+
+func TestDrain(t *testing.T) {
+	testCases := []struct {
+		name    string
+		release time.Duration
+		drained bool
+	}{
+		{name: "In-flight request completed", release: 10 * time.Millisecond, drained: true},
+		{name: "Drain period expired", release: time.Second, drained: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := newFakeService("v1")
+			svc.block = make(chan struct{})
+			addr := filepath.Join(t.TempDir(), "kleidi.socket")
+			s := newGRPCServer(addr, SocketOptions{Mode: 0600, UID: -1, GID: -1}, svc)
+			ln, err := s.listen()
+			if err != nil {
+				t.Fatal(err)
+			}
+			go s.serve(ln)
+
+			c, err := client.New(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			go c.Encrypt(context.Background(), "uid", []byte("secret"))
+			time.Sleep(50 * time.Millisecond)
+
+			time.AfterFunc(tc.release, func() { close(svc.block) })
+			if drained := s.Drain(200 * time.Millisecond); drained != tc.drained {
+				t.Fatalf("Drain() = %v, want %v", drained, tc.drained)
+			}
+		})
+	}
+}
+
+func TestListenUnixMode(t *testing.T) {
+	// the socket is never created with a looser mode than 0600, whatever the umask.
+	prev := syscall.Umask(0)
+	defer syscall.Umask(prev)
+
+	addr := filepath.Join(t.TempDir(), "kleidi.socket")
+	ln, err := listenUnix(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	info, err := os.Stat(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode&^0600 != 0 {
+		t.Fatalf("socket created with mode %v, want 0600 at most", mode)
+	}
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"github.com/beezy-dev/kleidi/internal/metrics"
+	"github.com/beezy-dev/kleidi/internal/providers"
+	"go.uber.org/zap"
+)
+
+const (
+	reloadTimeOut           = 30 * time.Second
+	reloadCheckInterval int = 10
+)
+
+// ReloadFunc reads the configuration again and returns the provider and its config.
+type ReloadFunc func() (provider string, providerConfig providers.ConfigSource, err error)
+
+// reloader rebuilds the provider instance behind the gRPC server on SIGHUP or
+// when one of the watched configuration files changes.
+type reloader struct {
+	provider  string
+	source    providers.ConfigSource
+	reload    ReloadFunc
+	watch     []string
+	swappable *swappableService
+	sums      map[string][sha256.Size]byte
+	// onSwap is called once a new provider instance is swapped, when set.
+	onSwap func()
+}
+
+func newReloader(provider string, source providers.ConfigSource, reload ReloadFunc, watch []string, swappable *swappableService) *reloader {
+	r := &reloader{
+		provider:  provider,
+		source:    source,
+		reload:    reload,
+		watch:     watch,
+		swappable: swappable,
+	}
+	r.sums = r.checksums()
+	return r
+}
+
+// files returns the configuration documents to watch, the provider config file included.
+func (r *reloader) files() []string {
+	files := append([]string{}, r.watch...)
+	if len(r.source.Inline) == 0 && len(r.source.File) != 0 {
+		files = append(files, r.source.File)
+	}
+	return files
+}
+
+// checksums returns the content checksum of the watched files. Polling the content
+// instead of the modification events also catches the Kubernetes ConfigMap and
+// Secret volumes, updated by swapping a symlink to a new directory.
+func (r *reloader) checksums() map[string][sha256.Size]byte {
+	sums := make(map[string][sha256.Size]byte)
+	for _, file := range r.files() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		sums[file] = sha256.Sum256(data)
+	}
+	return sums
+}
+
+// changed reports whether a watched file content changed since the last call.
+func (r *reloader) changed() bool {
+	sums := r.checksums()
+	changed := len(sums) != len(r.sums)
+	for file, sum := range sums {
+		if prev, ok := r.sums[file]; !ok || prev != sum {
+			changed = true
+		}
+	}
+	r.sums = sums
+	return changed
+}
+
+// run triggers a reload on SIGHUP and on a watched file change until ctx is done.
+func (r *reloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(time.Duration(reloadCheckInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			zap.L().Info("INFO: SIGHUP received, reloading provider config", logger.Provider(r.provider))
+			r.apply()
+			// the reload may have changed the watched files.
+			r.sums = r.checksums()
+		case <-ticker.C:
+			if r.changed() {
+				zap.L().Info("INFO: config change detected, reloading provider config", logger.Provider(r.provider), zap.Strings("files", r.files()))
+				r.apply()
+				r.sums = r.checksums()
+			}
+		}
+	}
+}
+
+// apply reloads the provider, recording the result. The current instance keeps
+// serving when the reload fails.
+func (r *reloader) apply() {
+	result, err := r.swap()
+	metrics.ConfigReloads.WithLabelValues(r.provider, result).Inc()
+	if err != nil {
+		zap.L().Error("provider config reload failed, keeping the current instance",
+			logger.Provider(r.provider), zap.String("result", result), zap.Error(err))
+		return
+	}
+	zap.L().Info("INFO: provider config reloaded", logger.Provider(r.provider), zap.Stringer("configfile", r.source))
+}
+
+// swap builds and health-checks a new provider instance, then swaps it behind the
+// gRPC server. The previous instance is restored if the new one fails once swapped,
+// otherwise it is closed when its calls in flight are finished.
+func (r *reloader) swap() (string, error) {
+	provider, source, err := r.reload()
+	if err != nil {
+		return "failure", err
+	}
+	if provider != r.provider {
+		return "failure", fmt.Errorf("/!\\ provider can not change from %s to %s without a restart", r.provider, provider)
+	}
+	if err := providers.ValidateConfig(provider, source); err != nil {
+		return "failure", err
+	}
+
+	remoteKMSService, err := providers.NewRemoteService(provider, source)
+	if err != nil {
+		return "failure", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reloadTimeOut)
+	defer cancel()
+	if err := providers.CheckStatus(ctx, provider, remoteKMSService); err != nil {
+		providers.Close(remoteKMSService)
+		return "failure", err
+	}
+
+	prev := r.swappable.swap(remoteKMSService)
+	if err := providers.CheckStatus(ctx, provider, r.swappable); err != nil {
+		r.swappable.restore(prev).retire()
+		return "rollback", err
+	}
+
+	r.source = source
+	if r.onSwap != nil {
+		r.onSwap()
+	}
+	go func() {
+		if err := prev.retire(); err != nil {
+			zap.L().Warn("closing the previous provider instance failed", logger.Provider(provider), zap.Error(err))
+		}
+	}()
+	return "success", nil
+}
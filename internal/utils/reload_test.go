@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/beezy-dev/kleidi/internal/providers"
+)
+
+// startFakeVault serves the Vault calls of the hvault provider connecting with the
+// cert auth method, the token lookup answering lookup with status.
+func startFakeVault(t *testing.T, status int, lookup string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/cert/login":
+			fmt.Fprint(w, `{"auth": {"client_token": "kleidi-token", "renewable": true, "lease_duration": 3600}}`)
+		case "/v1/transit/keys/kleidi":
+			fmt.Fprint(w, `{"data": {"latest_version": 1, "keys": {"1": 1700000000}}}`)
+		case "/v1/auth/token/lookup-self":
+			w.WriteHeader(status)
+			fmt.Fprint(w, lookup)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestReloadInvalidToken(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status int
+		lookup string
+	}{
+		{name: "Invalid token", status: http.StatusForbidden, lookup: `{"errors": ["invalid token"]}`},
+		{name: "Invalid ttl", status: http.StatusOK, lookup: `{"data": {"ttl": 0, "creation_ttl": 3600}}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			address := startFakeVault(t, tc.status, tc.lookup)
+			config := filepath.Join(t.TempDir(), "hvault.json")
+			if err := os.WriteFile(config, []byte(fmt.Sprintf(`{"transitkey": "kleidi", "address": %q, "authmethod": "cert"}`, address)), 0600); err != nil {
+				t.Fatal(err)
+			}
+			source := providers.ConfigSource{File: config}
+			reload := func() (string, providers.ConfigSource, error) { return "hvault", source, nil }
+			r := newReloader("hvault", source, reload, nil, newSwappableService(newFakeService("v1")))
+
+			// the reload fails without exiting, and the current instance keeps serving.
+			result, err := r.swap()
+			if result != "failure" || err == nil || !strings.Contains(err.Error(), "re-login needed") {
+				t.Fatalf("swap() = %s, %v, want a failure", result, err)
+			}
+			if status, err := r.swappable.Status(context.Background()); err != nil || status.KeyID != "v1" {
+				t.Fatalf("Status() after the failed reload = %v, %v", status, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// SocketOptions are the file mode and ownership applied to the unix socket.
+type SocketOptions struct {
+	Mode os.FileMode
+	// UID and GID are -1 to keep the ones of the kleidi process.
+	UID int
+	GID int
+}
+
+// ValidateSocketOptions parses the octal mode and resolves the owner and group, names or numeric ids.
+func ValidateSocketOptions(mode, owner, group string) (SocketOptions, error) {
+	opts := SocketOptions{UID: -1, GID: -1}
+
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return opts, fmt.Errorf("/!\\ invalid socket mode %q, expecting an octal mode like 0600", mode)
+	}
+	opts.Mode = os.FileMode(m)
+	if opts.Mode&0002 != 0 {
+		return opts, fmt.Errorf("/!\\ socket mode %q can not be world-writable", mode)
+	}
+
+	if len(owner) != 0 {
+		if opts.UID, err = lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return opts, fmt.Errorf("/!\\ invalid socket owner %q: %v", owner, err)
+		}
+	}
+	if len(group) != 0 {
+		if opts.GID, err = lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return opts, fmt.Errorf("/!\\ invalid socket group %q: %v", group, err)
+		}
+	}
+
+	zap.L().Info("INFO: socket permissions set", zap.Stringer("mode", opts.Mode), zap.Int("uid", opts.UID), zap.Int("gid", opts.GID))
+	return opts, nil
+}
+
+// lookupID returns the numeric id, or resolves the name with lookup.
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	id, err := lookup(nameOrID)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// ValidateSocketDir checks the directory of the unix socket: it must exist, must not be
+// world-writable, and should be owned by root or by the kleidi process, as anyone able
+// to write in it can replace the socket.
+func ValidateSocketDir(addr string) error {
+	// abstract sockets have no file on disk.
+	if IsAbstractSocket(addr) {
+		return nil
+	}
+
+	dir := filepath.Dir(addr)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("/!\\ invalid socket directory: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("/!\\ invalid socket directory %s: not a directory", dir)
+	}
+	if info.Mode().Perm()&0002 != 0 {
+		return fmt.Errorf("/!\\ socket directory %s is world-writable (%s), restrict it to the kleidi and the API server users", dir, info.Mode().Perm())
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if uid := int(stat.Uid); uid != 0 && uid != os.Geteuid() {
+			zap.L().Warn("socket directory owned by another user", zap.String("dir", dir), zap.Int("uid", uid))
+		}
+	}
+	if info.Mode().Perm()&0020 != 0 {
+		zap.L().Warn("socket directory is group-writable", zap.String("dir", dir), zap.Stringer("mode", info.Mode().Perm()))
+	}
+	return nil
+}
+
+// apply sets the mode and ownership of the socket file at addr.
+func (o SocketOptions) apply(addr string) error {
+	// abstract sockets have no file on disk.
+	if IsAbstractSocket(addr) {
+		return nil
+	}
+	// the ownership is set first, for the mode to never apply to the previous owner.
+	if o.UID != -1 || o.GID != -1 {
+		if err := os.Chown(addr, o.UID, o.GID); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(addr, o.Mode)
+}
+
+// umaskMu serializes the umask changes, the umask being process-wide.
+var umaskMu sync.Mutex
+
+// listenUnix creates the unix socket at addr with the 0600 mode at most, so that it is
+// not reachable by other users before its mode and ownership are applied.
+func listenUnix(addr string) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	prev := syscall.Umask(0177)
+	defer syscall.Umask(prev)
+	return net.Listen("unix", addr)
+}
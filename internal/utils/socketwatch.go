@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"github.com/beezy-dev/kleidi/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// checkSocket returns why the socket file of the current listener is gone, or an
+// empty string while it is in place.
+func (s *grpcServer) checkSocket() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	socket, err := os.Stat(s.addr)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return "removed"
+	case err != nil:
+		zap.L().Warn("socket check failed", zap.String("socket", s.addr), zap.Error(err))
+		return ""
+	case !os.SameFile(socket, s.socket):
+		return "replaced"
+	}
+	return ""
+}
+
+// recreate replaces the current listener by a new one on the same path. The
+// established connections are kept, only the listening socket is recreated.
+func (s *grpcServer) recreate() error {
+	s.mu.Lock()
+	old := s.ln
+	s.mu.Unlock()
+
+	// the listener must not unlink the path, now owned by the new socket or by a replacement.
+	if ul, ok := old.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	if err := os.Remove(s.addr); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	old.Close()
+
+	go func() {
+		if err := s.serve(ln); err != nil {
+			zap.L().Fatal("EXIT: failed to serve", zap.String("socket", s.addr), zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// watchSocket periodically checks the socket file until ctx is done, and recreates
+// the listener when the file got removed, e.g. by a cleanup of /tmp on the host,
+// or replaced.
+func (s *grpcServer) watchSocket(ctx context.Context) {
+	// abstract sockets have no file on disk, and systemd owns the activated socket.
+	if IsAbstractSocket(s.addr) || s.activated != nil {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(socketCheckInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reason := s.checkSocket()
+			if len(reason) == 0 {
+				continue
+			}
+			zap.L().Warn("socket "+reason+", recreating the listener", zap.String("socket", s.addr))
+			if err := s.recreate(); err != nil {
+				metrics.SocketRecreations.WithLabelValues(reason, "failure").Inc()
+				zap.L().Fatal("EXIT: unable to recreate the socket", zap.String("socket", s.addr), zap.Error(err))
+			}
+			metrics.SocketRecreations.WithLabelValues(reason, "success").Inc()
+			zap.L().Info("INFO: socket recreated", zap.String("socket", s.addr), zap.String("reason", reason))
+		}
+	}
+}
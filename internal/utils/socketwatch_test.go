@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecreateRemovedSocket(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "kleidi.socket")
+	s := newGRPCServer(addr, SocketOptions{Mode: 0600, UID: -1, GID: -1}, newFakeService("v1"))
+	defer s.Shutdown()
+	go s.ListenAndServe()
+
+	// wait for the listener.
+	for i := 0; len(s.checkSocket()) != 0; i++ {
+		if i == 100 {
+			t.Fatal("socket not created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	os.Remove(addr)
+	if reason := s.checkSocket(); reason != "removed" {
+		t.Fatalf("checkSocket() = %q, want removed", reason)
+	}
+	if err := s.recreate(); err != nil {
+		t.Fatalf("recreate() error: %v", err)
+	}
+	if reason := s.checkSocket(); reason != "" {
+		t.Fatalf("checkSocket() after recreate = %q, want none", reason)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "unix", addr)
+	if err != nil {
+		t.Fatalf("dial recreated socket: %v", err)
+	}
+	conn.Close()
+}
+
+func TestAbstractSocket(t *testing.T) {
+	addr := fmt.Sprintf("@kleidi-test-%d", os.Getpid())
+	s := newGRPCServer(addr, SocketOptions{Mode: 0600, UID: -1, GID: -1}, newFakeService("v1"))
+	defer s.Shutdown()
+
+	ln, err := s.listen()
+	if err != nil {
+		t.Fatalf("listen() error: %v", err)
+	}
+	go s.serve(ln)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// the watchdog ignores abstract sockets.
+	s.watchSocket(ctx)
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "unix", addr)
+	if err != nil {
+		t.Fatalf("dial abstract socket: %v", err)
+	}
+	conn.Close()
+}
@@ -2,108 +2,183 @@ package utils
 
 import (
 	"context"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
-	"errors"
 
+	"github.com/beezy-dev/kleidi/internal/audit"
+	"github.com/beezy-dev/kleidi/internal/health"
+	"github.com/beezy-dev/kleidi/internal/logger"
+	"github.com/beezy-dev/kleidi/internal/metrics"
+	"github.com/beezy-dev/kleidi/internal/peercred"
 	"github.com/beezy-dev/kleidi/internal/providers"
-	"k8s.io/kms/pkg/service"
+	"github.com/beezy-dev/kleidi/internal/tracing"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"k8s.io/kms/pkg/service"
 )
 
 const (
-	socketTimeOut = 10 * time.Second
+	socketTimeOut           = 10 * time.Second
 	socketCheckInterval int = 10
 )
 
-func StartProvider(addr, provider, providerConfig string, debug bool) {
+// Exit codes of the KMS plugin server.
+const (
+	// ExitOK reports a shutdown with all the in-flight requests completed and the backend sessions closed.
+	ExitOK = 0
+	// ExitFailure reports a startup or serving failure.
+	ExitFailure = 1
+	// ExitDrainTimeout reports in-flight requests cancelled at the end of the drain period.
+	ExitDrainTimeout = 3
+	// ExitCloseFailure reports backend sessions which could not be closed cleanly.
+	ExitCloseFailure = 4
+)
+
+// Options holds the settings of the KMS plugin server.
+type Options struct {
+	Addr string
+	// Listener is the socket passed by systemd socket activation, used instead of
+	// creating the socket at Addr when set.
+	Listener      net.Listener
+	SocketOptions SocketOptions
+	// AllowedPeers restricts the processes allowed to connect to the socket.
+	AllowedPeers   peercred.Policy
+	Provider       string
+	ProviderConfig providers.ConfigSource
+	Debug          bool
+	AuditLog       *audit.Logger
+	// Reload reads the configuration again on SIGHUP or when a watched file changes.
+	// Hot reload is disabled when nil.
+	Reload ReloadFunc
+	// WatchFiles are watched for changes in addition to the provider config file.
+	WatchFiles []string
+	// DrainTimeOut bounds the wait for the in-flight requests on shutdown.
+	DrainTimeOut time.Duration
+	// DecryptCache caches the Decrypt results in front of the provider, disabled by default.
+	DecryptCache DecryptCacheOptions
+}
+
+// StartProvider serves the provider until a termination signal, then returns the exit code.
+func StartProvider(opts Options) int {
 
-	switch provider {
-	case "softhsm":
-		startSofthsm(addr, provider, providerConfig, debug)
-	case "hvault":
-		startHvault(addr, provider, providerConfig, debug)
+	switch opts.Provider {
 	case "tpm":
-		startTpm(addr, provider, providerConfig, debug)
+		startTpm(opts.Addr, opts.Provider, opts.ProviderConfig, opts.Debug)
+		return ExitOK
+	default:
+		return startRemoteService(opts)
 	}
 }
 
-func startSofthsm(addr, provider, providerConfig string, debug bool) {
+func startRemoteService(opts Options) int {
 
-	remoteKMSService, err := providers.NewPKCS11RemoteService(providerConfig, "kleidi-kms-plugin")
+	addr, provider := opts.Addr, opts.Provider
+	remoteKMSService, err := providers.NewRemoteService(provider, opts.ProviderConfig)
 	if err != nil {
-		zap.L().Fatal("EXIT: remote KMS provider [" + provider + "] failed with error: " + err.Error())
+		zap.L().Fatal("EXIT: remote KMS provider failed", logger.Provider(provider), zap.Error(err))
 	}
+	// the provider instance can be swapped on configuration reload.
+	swappable := newSwappableService(remoteKMSService)
+
 	// catch SIG termination.
 	ctx := withShutdownSignal(context.Background())
-	grpcService := service.NewGRPCService(
+	var serverOpts []grpc.ServerOption
+	if opts.AllowedPeers.Enabled() {
+		serverOpts = append(serverOpts, grpc.Creds(peercred.NewTransportCredentials(opts.AllowedPeers, provider, opts.AuditLog)))
+	}
+	var backend service.Service = swappable
+	var cache *decryptCache
+	if opts.DecryptCache.Enabled() {
+		cache = newDecryptCache(provider, swappable, opts.DecryptCache)
+		backend = cache
+		go cache.run(ctx)
+	}
+	grpcService := newGRPCServer(
 		addr,
-		socketTimeOut,
-		remoteKMSService,
+		opts.SocketOptions,
+		wrapService(provider, backend, opts.AuditLog),
+		serverOpts...,
 	)
+	grpcService.activated = opts.Listener
+	health.Register(addr, swappable)
 	// starting service.
 	go func() {
 		if err := grpcService.ListenAndServe(); err != nil {
-			zap.L().Fatal("EXIT: failed to serve with error: " + err.Error())
+			zap.L().Fatal("EXIT: failed to serve", logger.Provider(provider), zap.String("socket", addr), zap.Error(err))
 		}
 	}()
 
+	if opts.Reload != nil {
+		r := newReloader(provider, opts.ProviderConfig, opts.Reload, opts.WatchFiles, swappable)
+		if cache != nil {
+			// the reloaded provider may no longer decrypt the cached ciphertexts.
+			r.onSwap = cache.purge
+		}
+		go r.run(ctx)
+	}
+
+	// periodically check the unix socket, recreate it if it got removed.
+	go grpcService.watchSocket(ctx)
+
 	<-ctx.Done()
-	grpcService.Shutdown()
+	return shutdown(grpcService, swappable, opts)
 }
 
-func startHvault(addr, provider, providerConfig string, debug bool) {
-
-	remoteKMSService, err := providers.NewVaultClientRemoteService(providerConfig)
-	if err != nil {
-		zap.L().Fatal("EXIT: remote KMS provider [" + provider + "] failed with error: " + err.Error())
+// shutdown stops accepting new connections, drains the in-flight requests within the
+// drain period, then closes the backend sessions, e.g. PKCS#11 logout or Vault token
+// revocation.
+func shutdown(grpcService *grpcServer, swappable *swappableService, opts Options) int {
+	code := ExitOK
+	// reporting not ready while draining.
+	health.Register(opts.Addr, nil)
+
+	zap.L().Info("INFO: shutting down, draining the in-flight requests", logger.Provider(opts.Provider), zap.Duration("drain", opts.DrainTimeOut))
+	if !grpcService.Drain(opts.DrainTimeOut) {
+		zap.L().Warn("drain period expired, in-flight requests cancelled", logger.Provider(opts.Provider), zap.Duration("drain", opts.DrainTimeOut))
+		code = ExitDrainTimeout
 	}
 
-	ctx := withShutdownSignal(context.Background())
-	grpcService := service.NewGRPCService(
-		addr,
-		socketTimeOut,
-		remoteKMSService,
-	)
+	closed := make(chan error, 1)
 	go func() {
-		if err := grpcService.ListenAndServe(); err != nil {
-			zap.L().Fatal("EXIT: failed to serve with error: " + err.Error())
-		}
+		closed <- swappable.current.Load().retire()
 	}()
-
-	// periodically check unix socket if it exists
-	// shutdown if it got removed
-	sockCheckDone := make(chan bool)
-	go func(done chan bool, unixSock string) {
-		ticker := time.NewTicker(time.Duration(socketCheckInterval) * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				if _, err := os.Stat(unixSock); errors.Is(err, os.ErrNotExist) {
-					zap.L().Fatal("EXIT: socket removed: " + err.Error())
-				}
-			}
+	select {
+	case err := <-closed:
+		if err != nil {
+			zap.L().Error("closing the backend sessions failed", logger.Provider(opts.Provider), zap.Error(err))
+			return ExitCloseFailure
 		}
-	}(sockCheckDone, addr)
-
-	<-ctx.Done()
-	sockCheckDone <- true
-	grpcService.Shutdown()
+	case <-time.After(providers.CloseTimeOut):
+		zap.L().Error("closing the backend sessions timed out", logger.Provider(opts.Provider), zap.Duration("timeout", providers.CloseTimeOut))
+		return ExitCloseFailure
+	}
 
+	zap.L().Info("INFO: shutdown complete", logger.Provider(opts.Provider), zap.Int("code", code))
+	return code
 }
 
-func startTpm(addr, provider, providerConfig string, debug bool) {
+func startTpm(addr, provider string, providerConfig providers.ConfigSource, debug bool) {
 
-	zap.L().Info("BETA: flag -provider " + provider + " with -listen " + addr + " and -configfile " + providerConfig + " currently unsafe to be used in production.")
+	zap.L().Info("BETA: provider currently unsafe to be used in production",
+		logger.Provider(provider), zap.String("socket", addr), zap.Stringer("configfile", providerConfig))
 	providers.TmpPlaceholder()
 
 }
 
+// wrapService chains the cross-cutting concerns around the provider service:
+// metrics, then tracing, then logging, then auditing when enabled.
+func wrapService(provider string, remoteKMSService service.Service, auditLog *audit.Logger) service.Service {
+	if auditLog != nil {
+		remoteKMSService = audit.NewAuditedService(provider, remoteKMSService, auditLog)
+	}
+	return metrics.NewInstrumentedService(provider,
+		tracing.NewTracedService(provider,
+			logger.NewLoggedService(provider, remoteKMSService)))
+}
+
 // withShutdownSignal returns a copy of the parent context that will close if
 // the process receives termination signals.
 func withShutdownSignal(ctx context.Context) context.Context {
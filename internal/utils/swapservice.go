@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/beezy-dev/kleidi/internal/providers"
+	"k8s.io/kms/pkg/service"
+)
+
+// errShuttingDown fails the calls once the current instance is retired on shutdown.
+var errShuttingDown = errors.New("/!\\ the provider is shutting down")
+
+// swappableService forwards the KMS calls to the current provider instance, which
+// can be replaced at runtime without interrupting the gRPC server.
+type swappableService struct {
+	current atomic.Pointer[serviceInstance]
+}
+
+// serviceInstance is a provider instance and the calls in flight against it.
+type serviceInstance struct {
+	service.Service
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newSwappableService(remoteKMSService service.Service) *swappableService {
+	s := &swappableService{}
+	s.current.Store(&serviceInstance{Service: remoteKMSService})
+	return s
+}
+
+// acquire returns the current instance, held until release. A call racing with a
+// swap retries against the instance that replaced the retired one, and fails once
+// the current instance itself is retired, on shutdown.
+func (s *swappableService) acquire() (*serviceInstance, error) {
+	for {
+		inst := s.current.Load()
+		inst.mu.RLock()
+		if !inst.closed {
+			return inst, nil
+		}
+		inst.mu.RUnlock()
+		if s.current.Load() == inst {
+			return nil, errShuttingDown
+		}
+	}
+}
+
+func (i *serviceInstance) release() {
+	i.mu.RUnlock()
+}
+
+// swap makes remoteKMSService the current instance and returns the previous one,
+// which keeps serving its calls in flight until it is retired.
+func (s *swappableService) swap(remoteKMSService service.Service) *serviceInstance {
+	return s.current.Swap(&serviceInstance{Service: remoteKMSService})
+}
+
+// restore makes prev the current instance again and returns the one it replaces.
+func (s *swappableService) restore(prev *serviceInstance) *serviceInstance {
+	return s.current.Swap(prev)
+}
+
+// retire waits for the calls in flight against inst, then releases its backend resources.
+func (i *serviceInstance) retire() error {
+	i.mu.Lock()
+	i.closed = true
+	i.mu.Unlock()
+	return providers.Close(i.Service)
+}
+
+func (s *swappableService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	inst, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer inst.release()
+	return inst.Encrypt(ctx, uid, plaintext)
+}
+
+func (s *swappableService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	inst, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer inst.release()
+	return inst.Decrypt(ctx, uid, req)
+}
+
+func (s *swappableService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	inst, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer inst.release()
+	return inst.Status(ctx)
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/kms/pkg/service"
+)
+
+type fakeService struct {
+	keyID  string
+	block  chan struct{}
+	closed chan struct{}
+}
+
+func newFakeService(keyID string) *fakeService {
+	return &fakeService{keyID: keyID, closed: make(chan struct{})}
+}
+
+func (f *fakeService) Encrypt(ctx context.Context, uid string, plaintext []byte) (*service.EncryptResponse, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	return &service.EncryptResponse{KeyID: f.keyID, Ciphertext: plaintext}, nil
+}
+
+func (f *fakeService) Decrypt(ctx context.Context, uid string, req *service.DecryptRequest) ([]byte, error) {
+	return req.Ciphertext, nil
+}
+
+func (f *fakeService) Status(ctx context.Context) (*service.StatusResponse, error) {
+	return &service.StatusResponse{Version: "v2", Healthz: "ok", KeyID: f.keyID}, nil
+}
+
+func (f *fakeService) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func TestSwappableServiceSwap(t *testing.T) {
+	v1, v2 := newFakeService("v1"), newFakeService("v2")
+	v1.block = make(chan struct{})
+	s := newSwappableService(v1)
+
+	// a call in flight against v1 while it is swapped.
+	inFlight := make(chan string)
+	go func() {
+		resp, _ := s.Encrypt(context.Background(), "uid", nil)
+		inFlight <- resp.KeyID
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	prev := s.swap(v2)
+	status, _ := s.Status(context.Background())
+	if status.KeyID != "v2" {
+		t.Fatalf("KeyID after swap = %q, want v2", status.KeyID)
+	}
+
+	retired := make(chan struct{})
+	go func() {
+		prev.retire()
+		close(retired)
+	}()
+	select {
+	case <-retired:
+		t.Fatal("previous instance retired with a call in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(v1.block)
+	if keyID := <-inFlight; keyID != "v1" {
+		t.Fatalf("in flight KeyID = %q, want v1", keyID)
+	}
+	<-retired
+	<-v1.closed
+}
+
+func TestSwappableServiceRestore(t *testing.T) {
+	v1, v2 := newFakeService("v1"), newFakeService("v2")
+	s := newSwappableService(v1)
+
+	prev := s.swap(v2)
+	s.restore(prev).retire()
+
+	status, _ := s.Status(context.Background())
+	if status.KeyID != "v1" {
+		t.Fatalf("KeyID after restore = %q, want v1", status.KeyID)
+	}
+	select {
+	case <-v2.closed:
+	default:
+		t.Fatal("rolled back instance not closed")
+	}
+}
+
+func TestSwappableServiceShutdown(t *testing.T) {
+	v1 := newFakeService("v1")
+	s := newSwappableService(v1)
+	s.current.Load().retire()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Encrypt(context.Background(), "uid", nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errShuttingDown) {
+			t.Fatalf("Encrypt() after shutdown = %v, want %v", err, errShuttingDown)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Encrypt() after shutdown did not return")
+	}
+}
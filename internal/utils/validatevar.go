@@ -6,6 +6,8 @@ import (
 	"slices"
 	"strings"
 	"go.uber.org/zap"
+
+	"github.com/beezy-dev/kleidi/internal/providers"
 )
 
 func ValidateListenAddr(listenAddr string) (string, error) {
@@ -32,18 +34,18 @@ func ValidateListenAddr(listenAddr string) (string, error) {
 		return strings.TrimPrefix(url.Path, "/"), nil
 	}
 
-	zap.L().Info("INFO: flag -listen set to " + listenAddr)
+	zap.L().Info("INFO: flag -listen set", zap.String("listen", listenAddr))
 	return url.Path, nil
 }
 
 func ValidateProvider(providerService string) (string, error) {
 
-	providerServices := []string{"hvault", "softhsm", "tpm"}
+	providerServices := []string{"hvault", "openbao", "softhsm", "tpm", "localkey", "kmip", "awskms", "azurekv", "gcpkms", "composite", "layered", "threshold", "kekcache"}
 	if !slices.Contains(providerServices, providerService) {
 		return providerService, fmt.Errorf("/!\\ flag -provider is not supported. Only %v are valid options", providerServices)
 	}
 
-	zap.L().Info("INFO: flag -provider set to " + providerService)
+	zap.L().Info("INFO: flag -provider set", zap.String("provider", providerService))
 	return providerService, nil
 }
 
@@ -53,7 +55,23 @@ func ValidateConfigfile(providerConfigFile string) (string, error) {
 		return providerConfigFile, fmt.Errorf("/!\\ can not be an empty string")
 	}
 
-	zap.L().Info("INFO: flag -configfile set to " + providerConfigFile)
+	zap.L().Info("INFO: flag -configfile set", zap.String("configfile", providerConfigFile))
 	return providerConfigFile, nil
 
 }
+
+// ValidateProviderConfig strictly decodes the provider config and checks it against the provider schema.
+func ValidateProviderConfig(provider string, providerConfig providers.ConfigSource) error {
+	if err := providers.ValidateConfig(provider, providerConfig); err != nil {
+		return err
+	}
+
+	zap.L().Info("INFO: provider config validated", zap.String("provider", provider), zap.Stringer("configfile", providerConfig))
+	return nil
+}
+
+// DryRunProvider connects and authenticates to the provider backend and checks the
+// key presence and the provider status, without opening the socket.
+func DryRunProvider(provider string, providerConfig providers.ConfigSource) error {
+	return providers.DryRun(provider, providerConfig)
+}